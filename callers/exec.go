@@ -0,0 +1,208 @@
+package callers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// ExecPattern 表示标准错误输出的匹配规则
+type ExecPattern struct {
+	Pattern string      // 正则表达式
+	Code    lib.RetCode // 匹配时使用的结果代码
+}
+
+// ExecConfig 表示命令调用器的配置
+type ExecConfig struct {
+	Path           string              // 命令路径,不含路径分隔符时在PATH中查找
+	Args           []string            // 参数模板,可使用的数据见TemplateData
+	Stdin          string              // 标准输入模板,为空时不提供标准输入
+	Dir            string              // 工作目录,为空时使用当前目录
+	Env            []string            // 额外的环境变量,形如KEY=VALUE
+	ExitCodes      map[int]lib.RetCode // 退出码与结果代码的对应关系,未列出的非零退出码视为RET_CODE_ERROR_CALEE
+	StderrPatterns []ExecPattern       // 标准错误输出的匹配规则,按顺序检查,匹配时优先于退出码
+}
+
+// execReq 表示序列化在原生请求中的命令调用
+type execReq struct {
+	Args  []string
+	Stdin string
+}
+
+// execResp 表示序列化在原生响应中的命令执行结果
+type execResp struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// execPattern 表示编译后的匹配规则
+type execPattern struct {
+	re   *regexp.Regexp
+	code lib.RetCode
+}
+
+// ExecCaller 表示以子进程方式调用命令行程序的调用器
+type ExecCaller struct {
+	cfg         ExecConfig
+	feederNames // 请求模板中使用的已注册数据源
+	args        []*template.Template
+	stdin       *template.Template
+	patterns    []execPattern
+	seq         int64 // 请求序号
+}
+
+// NewExecCaller 新建一个命令调用器
+func NewExecCaller(cfg ExecConfig) (lib.Caller, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("Invalid command path!")
+	}
+	if _, err := exec.LookPath(cfg.Path); err != nil {
+		return nil, err
+	}
+	caller := &ExecCaller{cfg: cfg}
+	for i, text := range cfg.Args {
+		tmpl, err := parseTemplate("arg"+strconv.Itoa(i), text)
+		if err != nil {
+			return nil, err
+		}
+		caller.args = append(caller.args, tmpl)
+	}
+	if cfg.Stdin != "" {
+		tmpl, err := parseTemplate("stdin", cfg.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		caller.stdin = tmpl
+	}
+	caller.feederNames = templateFeeders(append([]*template.Template{caller.stdin}, caller.args...)...)
+	for _, p := range cfg.StderrPatterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stderr pattern: %s", err)
+		}
+		caller.patterns = append(caller.patterns, execPattern{re: re, code: p.Code})
+	}
+	return caller, nil
+}
+
+// BuildReq 依据模板构建命令参数和标准输入
+func (caller *ExecCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	req := execReq{Args: make([]string, len(caller.args))}
+	r := &renderer{data: data}
+	for i, tmpl := range caller.args {
+		req.Args[i] = r.render(tmpl)
+	}
+	if caller.stdin != nil {
+		req.Stdin = r.render(caller.stdin)
+	}
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: data.ID, Req: reqBytes}
+}
+
+// Call 在独立的进程组中运行命令,超时时结束整个进程组
+// 非零的退出码不视为调用错误,而是记录在响应中由CheckResp判定
+func (caller *ExecCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var r execReq
+	if err := json.Unmarshal(req, &r); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(caller.cfg.Path, r.Args...)
+	cmd.Dir = caller.cfg.Dir
+	if len(caller.cfg.Env) > 0 {
+		cmd.Env = append(cmd.Environ(), caller.cfg.Env...)
+	}
+	if caller.stdin != nil {
+		cmd.Stdin = bytes.NewReader([]byte(r.Stdin))
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	// 进程组被结束后仍有子进程占用输出管道时,不再无限等待
+	cmd.WaitDelay = 100 * time.Millisecond
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timer := time.NewTimer(timeoutNS)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		killProcessGroup(cmd)
+		<-done
+		return nil, fmt.Errorf("Command killed after timeout! (timeout=%v)", timeoutNS)
+	}
+	resp := execResp{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		resp.ExitCode = exitErr.ExitCode()
+	}
+	return json.Marshal(resp)
+}
+
+// CheckResp 依据标准错误输出和退出码判定结果,标准输出作为响应内容
+func (caller *ExecCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var resp execResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	result.SetTag("exec.exit", strconv.Itoa(resp.ExitCode))
+	for _, p := range caller.patterns {
+		if loc := p.re.FindIndex(resp.Stderr); loc != nil {
+			result.Code = p.code
+			result.Msg = fmt.Sprintf("Stderr matched: %s (exit=%d)", resp.Stderr[loc[0]:loc[1]], resp.ExitCode)
+			return &result
+		}
+	}
+	code, ok := caller.cfg.ExitCodes[resp.ExitCode]
+	switch {
+	case ok:
+		result.Code = code
+	case resp.ExitCode == 0:
+		result.Code = lib.RET_CODE_SUCCESS
+	default:
+		result.Code = lib.RET_CODE_ERROR_CALEE
+	}
+	if result.Code == lib.RET_CODE_SUCCESS {
+		result.Msg = fmt.Sprintf("Success. (%s)", bytes.TrimSpace(resp.Stdout))
+	} else {
+		result.Msg = fmt.Sprintf("Exit %d: %s", resp.ExitCode, bytes.TrimSpace(resp.Stderr))
+	}
+	return &result
+}
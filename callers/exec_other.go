@@ -0,0 +1,13 @@
+//go:build !unix
+
+package callers
+
+import "os/exec"
+
+// setProcessGroup 在不支持进程组的平台上不做处理
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup 在不支持进程组的平台上只结束命令本身
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
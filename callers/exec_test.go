@@ -0,0 +1,53 @@
+package callers
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+)
+
+func TestExecCaller(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	script := `read line; echo "$line-$1"; case "$1" in 2) exit 3;; 3) echo "fatal: disk full" >&2; exit 1;; esac`
+	caller, err := NewExecCaller(ExecConfig{
+		Path:      "/bin/sh",
+		Args:      []string{"-c", script, "sh", "{{.Seq}}"},
+		Stdin:     "hello\n",
+		ExitCodes: map[int]lib.RetCode{3: lib.RET_CODE_ERROR_RESPONSE},
+		StderrPatterns: []ExecPattern{
+			{Pattern: `fatal: .*`, Code: lib.RET_CODE_FATAL_CALL},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []lib.RetCode{lib.RET_CODE_SUCCESS, lib.RET_CODE_ERROR_RESPONSE, lib.RET_CODE_FATAL_CALL}
+	for i, code := range expected {
+		result := call(caller, time.Second)
+		if result.Code != code {
+			t.Fatalf("Call %d: unexpected result: %v", i+1, result)
+		}
+		if i == 0 && result.Msg != "Success. (hello-1)" {
+			t.Fatalf("Unexpected message: %s", result.Msg)
+		}
+	}
+
+	// 超时时结束整个进程组,包括后台的子进程
+	caller, err = NewExecCaller(ExecConfig{Path: "/bin/sh", Args: []string{"-c", "sleep 5 & sleep 5; wait"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = caller.Call(caller.BuildReq().Req, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("Expected a timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Process group was not killed in time! (elapsed=%v)", elapsed)
+	}
+}
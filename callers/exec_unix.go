@@ -0,0 +1,18 @@
+//go:build unix
+
+package callers
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup 使命令在新的进程组中运行
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup 结束命令所在的整个进程组
+func killProcessGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
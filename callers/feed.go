@@ -0,0 +1,124 @@
+package callers
+
+import (
+	"sort"
+	"text/template"
+	"text/template/parse"
+
+	"loadgen/lib"
+)
+
+// feederNames 表示调用器的请求模板中使用的已注册数据源,实现lib.FeederUser
+type feederNames []string
+
+// Feeders 返回请求模板中以字符串常量调用feed函数的数据源名称
+func (names feederNames) Feeders() []string {
+	return names
+}
+
+// templateFeeders 返回模板中以字符串常量调用feed函数的数据源名称,已排序且不重复
+func templateFeeders(tmpls ...*template.Template) feederNames {
+	set := make(map[string]bool)
+	for _, tmpl := range tmpls {
+		walkCommands(tmpl, func(cmd *parse.CommandNode) {
+			if len(cmd.Args) != 2 {
+				return
+			}
+			ident, ok1 := cmd.Args[0].(*parse.IdentifierNode)
+			str, ok2 := cmd.Args[1].(*parse.StringNode)
+			if ok1 && ok2 && ident.Ident == "feed" {
+				set[str.Text] = true
+			}
+		})
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	names := make(feederNames, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usesFeed 判断模板是否调用了feed函数,包括以变量作为数据源名称的调用
+func usesFeed(tmpl *template.Template) bool {
+	found := false
+	walkCommands(tmpl, func(cmd *parse.CommandNode) {
+		for _, arg := range cmd.Args {
+			if ident, ok := arg.(*parse.IdentifierNode); ok && ident.Ident == "feed" {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// walkCommands 遍历模板及其关联模板语法树中的所有命令
+func walkCommands(tmpl *template.Template, visit func(*parse.CommandNode)) {
+	if tmpl == nil {
+		return
+	}
+	var walk func(parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n != nil {
+				for _, child := range n.Nodes {
+					walk(child)
+				}
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n != nil {
+				for _, cmd := range n.Cmds {
+					walk(cmd)
+				}
+			}
+		case *parse.CommandNode:
+			visit(n)
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+}
+
+// feed 读取本次请求中数据源的记录,同名数据源在同一请求的所有模板中只读取一次
+func (r *renderer) feed(name string) (lib.Record, error) {
+	if record, ok := r.feeds[name]; ok {
+		return record, nil
+	}
+	record, err := lib.Feed(name)
+	if err != nil {
+		return nil, err
+	}
+	if r.feeds == nil {
+		r.feeds = make(map[string]lib.Record)
+	}
+	r.feeds[name] = record
+	return record, nil
+}
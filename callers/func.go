@@ -0,0 +1,75 @@
+package callers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"loadgen/lib"
+)
+
+// Func 表示被调用的进程内函数,应在ctx结束时尽快返回
+type Func func(ctx context.Context, req []byte) ([]byte, error)
+
+// FuncConfig 表示函数调用器的配置
+type FuncConfig struct {
+	Func Func // 被调用的函数
+	// Build 依据请求序号生成请求内容,为nil时请求内容为空
+	Build func(seq int64) []byte
+	// Check 检查请求对应的响应内容,返回结果代码和说明;为nil时函数未返回错误即视为成功
+	Check func(req, resp []byte) (lib.RetCode, string)
+}
+
+// FuncCaller 表示直接调用进程内函数的调用器
+// 请求和响应不经过序列化,以便载荷发生器的速率控制、超时和统计直接作用于Go代码
+type FuncCaller struct {
+	cfg FuncConfig
+	seq int64 // 请求序号
+}
+
+// NewFuncCaller 新建一个函数调用器
+func NewFuncCaller(cfg FuncConfig) (lib.Caller, error) {
+	if cfg.Func == nil {
+		return nil, errors.New("Invalid function!")
+	}
+	return &FuncCaller{cfg: cfg}, nil
+}
+
+// BuildReq 构建一个请求
+func (caller *FuncCaller) BuildReq() lib.RawReq {
+	seq := atomic.AddInt64(&caller.seq, 1)
+	req := lib.RawReq{ID: time.Now().UnixNano()}
+	if caller.cfg.Build != nil {
+		req.Req = caller.cfg.Build(seq)
+	}
+	return req
+}
+
+// EditRequest 原生请求即为发出的载荷,协议不支持头部
+func (caller *FuncCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	return edit(req, nil)
+}
+
+// Call 在带超时的上下文中调用函数
+func (caller *FuncCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	return caller.cfg.Func(ctx, req)
+}
+
+// CheckResp 检查函数的返回值
+func (caller *FuncCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+	if caller.cfg.Check == nil {
+		result.Code = lib.RET_CODE_SUCCESS
+		result.Msg = fmt.Sprintf("Success. (%d bytes)", len(rawResp.Resp))
+		return &result
+	}
+	result.Code, result.Msg = caller.cfg.Check(rawReq.Req, rawResp.Resp)
+	return &result
+}
@@ -0,0 +1,49 @@
+package callers
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+)
+
+func TestFuncCaller(t *testing.T) {
+	caller, err := NewFuncCaller(FuncConfig{
+		Func: func(ctx context.Context, req []byte) ([]byte, error) {
+			if bytes.Equal(req, []byte("3")) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return bytes.Repeat(req, 2), nil
+		},
+		Build: func(seq int64) []byte {
+			return []byte(strconv.FormatInt(seq, 10))
+		},
+		Check: func(req, resp []byte) (lib.RetCode, string) {
+			if !bytes.Equal(resp, append(req, req...)) {
+				return lib.RET_CODE_ERROR_RESPONSE, string(resp)
+			}
+			return lib.RET_CODE_SUCCESS, string(resp)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		result := call(caller, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS || result.Msg != strconv.Itoa(i)+strconv.Itoa(i) {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+	}
+	// 函数可通过ctx感知调用超时
+	if _, err := caller.Call(caller.BuildReq().Req, 10*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("Expected a deadline error, got: %v", err)
+	}
+
+	if _, err := NewFuncCaller(FuncConfig{}); err == nil {
+		t.Fatal("Expected an error without function!")
+	}
+}
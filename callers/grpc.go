@@ -0,0 +1,272 @@
+package callers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 调用结果的gRPC相关标签
+const (
+	TAG_GRPC_METHOD = "grpc.method" // 方法全名
+	TAG_GRPC_STATUS = "grpc.status" // 状态码
+)
+
+// gRPC状态码
+const (
+	GRPC_OK                  = 0
+	GRPC_CANCELLED           = 1
+	GRPC_UNKNOWN             = 2
+	GRPC_INVALID_ARGUMENT    = 3
+	GRPC_DEADLINE_EXCEEDED   = 4
+	GRPC_NOT_FOUND           = 5
+	GRPC_ALREADY_EXISTS      = 6
+	GRPC_PERMISSION_DENIED   = 7
+	GRPC_RESOURCE_EXHAUSTED  = 8
+	GRPC_FAILED_PRECONDITION = 9
+	GRPC_ABORTED             = 10
+	GRPC_OUT_OF_RANGE        = 11
+	GRPC_UNIMPLEMENTED       = 12
+	GRPC_INTERNAL            = 13
+	GRPC_UNAVAILABLE         = 14
+	GRPC_DATA_LOSS           = 15
+	GRPC_UNAUTHENTICATED     = 16
+)
+
+// GRPCConfig 表示gRPC调用器的配置
+// 请求消息以JSON编码,依据描述符集合(protoc --descriptor_set_out生成的
+// FileDescriptorSet)转换为protobuf;消息与JSON之间按proto3 JSON映射转换,
+// 其中枚举值以名称表示(也接受数值),映射字段以JSON对象表示,64位整数以字符串表示;
+// 暂不支持服务端反射、客户端流、压缩、group字段和Any等知名类型的特殊JSON形式
+type GRPCConfig struct {
+	Address       string              // 服务地址,形如host:port
+	Method        string              // 方法全名,形如pkg.Service/Method
+	Requests      []string            // JSON编码的请求消息,依次轮流使用
+	DescriptorSet []byte              // 序列化的FileDescriptorSet
+	Metadata      map[string]string   // 请求元数据
+	TLSConfig     *tls.Config         // TLS配置,为nil时使用明文的h2c
+	Conns         int                 // 连接数,默认为1
+	StatusCodes   map[int]lib.RetCode // gRPC状态码到结果代码的显式映射,优先于默认映射
+}
+
+// grpcReq 表示序列化在原生请求中的gRPC请求
+type grpcReq struct {
+	Index   int             // 请求消息的序号
+	Message json.RawMessage // JSON编码的请求消息
+}
+
+// grpcResp 表示序列化在原生响应中的gRPC响应
+type grpcResp struct {
+	Status   int
+	Message  string
+	Messages []json.RawMessage // JSON编码的响应消息
+}
+
+// GRPCCaller 表示gRPC调用器,支持一元调用和服务端流式调用
+type GRPCCaller struct {
+	cfg      GRPCConfig
+	reg      *protoRegistry
+	method   *protoMethod
+	url      string
+	payloads [][]byte       // 编码后的请求消息
+	clients  []*http.Client // 客户端连接
+	seq      uint64         // 请求序号
+	next     uint64         // 下一个使用的连接序号
+}
+
+// NewGRPCCaller 新建一个gRPC调用器
+func NewGRPCCaller(cfg GRPCConfig) (lib.Caller, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("Invalid gRPC address!")
+	}
+	if len(cfg.Requests) == 0 {
+		return nil, errors.New("Invalid gRPC requests: at least one is required!")
+	}
+	reg, err := parseDescriptorSet(cfg.DescriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid descriptor set: %s", err)
+	}
+	method, ok := reg.methods[strings.TrimPrefix(cfg.Method, "/")]
+	if !ok {
+		return nil, fmt.Errorf("Unknown gRPC method: %s!", cfg.Method)
+	}
+	if method.clientStreaming {
+		return nil, fmt.Errorf("Client streaming is not supported! (method=%s)", cfg.Method)
+	}
+	caller := &GRPCCaller{cfg: cfg, reg: reg, method: method}
+	for i, req := range cfg.Requests {
+		payload, err := reg.encodeJSON(method.input, []byte(req))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid gRPC request %d: %s", i, err)
+		}
+		caller.payloads = append(caller.payloads, payload)
+	}
+	scheme := "http"
+	if cfg.TLSConfig != nil {
+		scheme = "https"
+	}
+	caller.url = (&url.URL{Scheme: scheme, Host: cfg.Address, Path: method.path}).String()
+	if cfg.Conns <= 0 {
+		cfg.Conns = 1
+	}
+	for i := 0; i < cfg.Conns; i++ {
+		transport := newHTTP2Transport(cfg.TLSConfig == nil, cfg.TLSConfig)
+		caller.clients = append(caller.clients, &http.Client{Transport: transport})
+	}
+	return caller, nil
+}
+
+// BuildReq 依次选取一个请求消息构建请求
+func (caller *GRPCCaller) BuildReq() lib.RawReq {
+	n := atomic.AddUint64(&caller.seq, 1)
+	index := int((n - 1) % uint64(len(caller.payloads)))
+	mBytes, err := json.Marshal(grpcReq{
+		Index:   index,
+		Message: json.RawMessage(caller.cfg.Requests[index]),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: time.Now().UnixNano(), Req: mBytes}
+}
+
+// Call 发起一次gRPC调用,读取全部响应消息和状态
+func (caller *GRPCCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var greq grpcReq
+	if err := json.Unmarshal(req, &greq); err != nil {
+		return nil, err
+	}
+	if greq.Index < 0 || greq.Index >= len(caller.payloads) {
+		return nil, fmt.Errorf("Invalid gRPC request index: %d!", greq.Index)
+	}
+	payload := caller.payloads[greq.Index]
+	body := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(payload)))
+	body = append(body, payload...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	request, err := http.NewRequest(http.MethodPost, caller.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+	request.Header.Set("Grpc-Timeout", strconv.FormatInt(int64(timeoutNS/time.Millisecond)+1, 10)+"m")
+	for k, v := range caller.cfg.Metadata {
+		request.Header.Set(k, v)
+	}
+	client := caller.clients[atomic.AddUint64(&caller.next, 1)%uint64(len(caller.clients))]
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected HTTP status: %s", response.Status)
+	}
+	// 仅有尾部的响应会把状态放在响应头中
+	status := response.Trailer.Get("Grpc-Status")
+	message := response.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = response.Header.Get("Grpc-Status")
+		message = response.Header.Get("Grpc-Message")
+	}
+	gresp := grpcResp{Status: GRPC_UNKNOWN, Message: "missing grpc-status"}
+	if status != "" {
+		if gresp.Status, err = strconv.Atoi(status); err != nil {
+			return nil, fmt.Errorf("Invalid grpc-status: %q", status)
+		}
+		gresp.Message, _ = url.PathUnescape(message)
+	}
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, errors.New("Truncated gRPC message!")
+		}
+		if data[0] != 0 {
+			return nil, errors.New("Compressed gRPC message is not supported!")
+		}
+		n := binary.BigEndian.Uint32(data[1:5])
+		if uint32(len(data)-5) < n {
+			return nil, errors.New("Truncated gRPC message!")
+		}
+		obj, err := caller.reg.decode(caller.method.output, data[5:5+n])
+		if err != nil {
+			return nil, err
+		}
+		mBytes, err := json.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		gresp.Messages = append(gresp.Messages, mBytes)
+		data = data[5+n:]
+	}
+	return json.Marshal(gresp)
+}
+
+// CheckResp 依据gRPC状态检查响应内容
+func (caller *GRPCCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+	result.SetTag(TAG_GRPC_METHOD, strings.TrimPrefix(caller.method.path, "/"))
+
+	var gresp grpcResp
+	if err := json.Unmarshal(rawResp.Resp, &gresp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	result.SetTag(TAG_GRPC_STATUS, strconv.Itoa(gresp.Status))
+	result.Code = caller.statusCode(gresp.Status)
+	if result.Code != lib.RET_CODE_SUCCESS {
+		result.Msg = fmt.Sprintf("Abnormal gRPC status: %d (%s)!", gresp.Status, gresp.Message)
+		return &result
+	}
+	if !caller.method.serverStreaming && len(gresp.Messages) != 1 {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Unexpected message count of unary call: %d!", len(gresp.Messages))
+		return &result
+	}
+	result.Msg = fmt.Sprintf("Success. (%d messages)", len(gresp.Messages))
+	return &result
+}
+
+// statusCode 把gRPC状态码映射为结果代码
+func (caller *GRPCCaller) statusCode(status int) lib.RetCode {
+	if code, ok := caller.cfg.StatusCodes[status]; ok {
+		return code
+	}
+	switch status {
+	case GRPC_OK:
+		return lib.RET_CODE_SUCCESS
+	case GRPC_DEADLINE_EXCEEDED:
+		return lib.RET_CODE_WARNING_CALL_TIMEOUT
+	case GRPC_CANCELLED, GRPC_UNAVAILABLE:
+		return lib.RET_CODE_ERROR_CALL
+	case GRPC_INVALID_ARGUMENT, GRPC_NOT_FOUND, GRPC_ALREADY_EXISTS,
+		GRPC_PERMISSION_DENIED, GRPC_FAILED_PRECONDITION, GRPC_OUT_OF_RANGE,
+		GRPC_UNIMPLEMENTED, GRPC_UNAUTHENTICATED:
+		return lib.RET_CODE_ERROR_RESPONSE
+	}
+	return lib.RET_CODE_ERROR_CALEE
+}
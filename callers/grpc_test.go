@@ -0,0 +1,247 @@
+package callers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+)
+
+// testField 构建一个FieldDescriptorProto
+func testField(name string, number, typ int) []byte {
+	var b []byte
+	b = appendBytes(b, 1, []byte(name))
+	b = appendVarint(appendTag(b, 3, wireVarint), uint64(number))
+	b = appendVarint(appendTag(b, 4, wireVarint), 1)
+	b = appendVarint(appendTag(b, 5, wireVarint), uint64(typ))
+	return b
+}
+
+// testDescriptorSet 构建计算服务的描述符集合:
+//
+//	package calc;
+//	message AddRequest { int32 a = 1; int32 b = 2; }
+//	message AddReply { int64 sum = 1; string formula = 2; }
+//	service Calc {
+//	  rpc Add(AddRequest) returns (AddReply);
+//	  rpc Count(AddRequest) returns (stream AddReply);
+//	}
+func testDescriptorSet() []byte {
+	var req, reply, add, count, service, file []byte
+	req = appendBytes(req, 1, []byte("AddRequest"))
+	req = appendBytes(req, 2, testField("a", 1, protoInt32))
+	req = appendBytes(req, 2, testField("b", 2, protoInt32))
+	reply = appendBytes(reply, 1, []byte("AddReply"))
+	reply = appendBytes(reply, 2, testField("sum", 1, protoInt64))
+	reply = appendBytes(reply, 2, testField("formula", 2, protoString))
+	add = appendBytes(add, 1, []byte("Add"))
+	add = appendBytes(add, 2, []byte(".calc.AddRequest"))
+	add = appendBytes(add, 3, []byte(".calc.AddReply"))
+	count = appendBytes(count, 1, []byte("Count"))
+	count = appendBytes(count, 2, []byte(".calc.AddRequest"))
+	count = appendBytes(count, 3, []byte(".calc.AddReply"))
+	count = appendVarint(appendTag(count, 6, wireVarint), 1)
+	service = appendBytes(service, 1, []byte("Calc"))
+	service = appendBytes(service, 2, add)
+	service = appendBytes(service, 2, count)
+	file = appendBytes(file, 1, []byte("calc.proto"))
+	file = appendBytes(file, 2, []byte("calc"))
+	file = appendBytes(file, 4, req)
+	file = appendBytes(file, 4, reply)
+	file = appendBytes(file, 6, service)
+	return appendBytes(nil, 1, file)
+}
+
+// testGRPCHandler 表示进程内的gRPC计算服务
+func testGRPCHandler(reg *protoRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		fail := func(status, msg string) {
+			w.Header().Set("Grpc-Status", status)
+			w.Header().Set("Grpc-Message", msg)
+			w.WriteHeader(http.StatusOK)
+		}
+		if len(data) < 5 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			fail("13", "bad request")
+			return
+		}
+		req, err := reg.decode("calc.AddRequest", data[5:])
+		if err != nil {
+			fail("13", err.Error())
+			return
+		}
+		a, _ := req["a"].(int32)
+		b, _ := req["b"].(int32)
+		if a < 0 {
+			fail("3", "negative operand")
+			return
+		}
+		n := 1
+		if r.URL.Path == "/calc.Calc/Count" {
+			n = int(b)
+		}
+		for i := 0; i < n; i++ {
+			obj := map[string]interface{}{"sum": json.Number(strconv.Itoa(int(a + b))), "formula": "a+b"}
+			payload, _ := reg.encode("calc.AddReply", obj)
+			frame := make([]byte, 5)
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+			w.Write(append(frame, payload...))
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+}
+
+func TestGRPCCaller(t *testing.T) {
+	descSet := testDescriptorSet()
+	reg, err := parseDescriptorSet(descSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(testGRPCHandler(reg))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	caller, err := NewGRPCCaller(GRPCConfig{
+		Address:       addr,
+		Method:        "calc.Calc/Add",
+		Requests:      []string{`{"a": 1, "b": 2}`, `{"a": -1, "b": 2}`},
+		DescriptorSet: descSet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := call(caller, time.Second)
+	if result.Code != lib.RET_CODE_SUCCESS || result.Tag(TAG_GRPC_METHOD) != "calc.Calc/Add" {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	var gresp grpcResp
+	if err := json.Unmarshal(result.Resp.Resp, &gresp); err != nil {
+		t.Fatal(err)
+	}
+	if len(gresp.Messages) != 1 || string(gresp.Messages[0]) != `{"formula":"a+b","sum":"3"}` {
+		t.Fatalf("Unexpected messages: %s", gresp.Messages)
+	}
+	result = call(caller, time.Second)
+	if result.Code != lib.RET_CODE_ERROR_RESPONSE || result.Tag(TAG_GRPC_STATUS) != "3" {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	caller, err = NewGRPCCaller(GRPCConfig{
+		Address:       addr,
+		Method:        "/calc.Calc/Count",
+		Requests:      []string{`{"a": 1, "b": 3}`},
+		DescriptorSet: descSet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = call(caller, time.Second)
+	if result.Code != lib.RET_CODE_SUCCESS || !strings.Contains(result.Msg, "3 messages") {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	_, err = NewGRPCCaller(GRPCConfig{
+		Address:       addr,
+		Method:        "calc.Calc/Add",
+		Requests:      []string{`{"c": 1}`},
+		DescriptorSet: descSet,
+	})
+	if err == nil {
+		t.Fatal("Invalid request passed the construction!")
+	}
+}
+
+// testTypedField 构建一个指定标签和类型名的FieldDescriptorProto
+func testTypedField(name string, number, label, typ int, typeName string) []byte {
+	var b []byte
+	b = appendBytes(b, 1, []byte(name))
+	b = appendVarint(appendTag(b, 3, wireVarint), uint64(number))
+	b = appendVarint(appendTag(b, 4, wireVarint), uint64(label))
+	b = appendVarint(appendTag(b, 5, wireVarint), uint64(typ))
+	return appendBytes(b, 6, []byte(typeName))
+}
+
+// testEnum 构建一个EnumDescriptorProto
+func testEnum(name string, values ...string) []byte {
+	b := appendBytes(nil, 1, []byte(name))
+	for i, v := range values {
+		value := appendBytes(nil, 1, []byte(v))
+		value = appendVarint(appendTag(value, 2, wireVarint), uint64(i))
+		b = appendBytes(b, 2, value)
+	}
+	return b
+}
+
+// testMapEntry 构建映射字段的键值对消息
+func testMapEntry(name string, keyType, valueType int, valueTypeName string) []byte {
+	b := appendBytes(nil, 1, []byte(name))
+	b = appendBytes(b, 2, testField("key", 1, keyType))
+	b = appendBytes(b, 2, testTypedField("value", 2, 1, valueType, valueTypeName))
+	return appendBytes(b, 7, appendVarint(appendTag(nil, 7, wireVarint), 1))
+}
+
+// TestProtoEnumAndMap 测试枚举和映射字段的proto3 JSON映射:
+//
+//	package shop;
+//	enum Status { UNKNOWN = 0; ACTIVE = 1; }
+//	message Order {
+//	  enum Priority { LOW = 0; HIGH = 1; }
+//	  Status status = 1;
+//	  repeated Status history = 2;
+//	  map<string, int32> counts = 3;
+//	  map<int64, Status> states = 4;
+//	  Priority priority = 5;
+//	}
+func TestProtoEnumAndMap(t *testing.T) {
+	var order, file []byte
+	order = appendBytes(order, 1, []byte("Order"))
+	order = appendBytes(order, 2, testTypedField("status", 1, 1, protoEnum, ".shop.Status"))
+	order = appendBytes(order, 2, testTypedField("history", 2, protoLabelRepeated, protoEnum, ".shop.Status"))
+	order = appendBytes(order, 2, testTypedField("counts", 3, protoLabelRepeated, protoMessage, ".shop.Order.CountsEntry"))
+	order = appendBytes(order, 2, testTypedField("states", 4, protoLabelRepeated, protoMessage, ".shop.Order.StatesEntry"))
+	order = appendBytes(order, 2, testTypedField("priority", 5, 1, protoEnum, ".shop.Order.Priority"))
+	order = appendBytes(order, 3, testMapEntry("CountsEntry", protoString, protoInt32, ""))
+	order = appendBytes(order, 3, testMapEntry("StatesEntry", protoInt64, protoEnum, ".shop.Status"))
+	order = appendBytes(order, 4, testEnum("Priority", "LOW", "HIGH"))
+	file = appendBytes(file, 2, []byte("shop"))
+	file = appendBytes(file, 4, order)
+	file = appendBytes(file, 5, testEnum("Status", "UNKNOWN", "ACTIVE"))
+	reg, err := parseDescriptorSet(appendBytes(nil, 1, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for req, want := range map[string]string{
+		`{"status":"ACTIVE","history":["ACTIVE",0],"counts":{"b":2,"a":1,"z":0},"states":{"7":"ACTIVE","8":1},"priority":"HIGH"}`: `{"counts":{"a":1,"b":2,"z":0},"history":["ACTIVE","UNKNOWN"],"priority":"HIGH","states":{"7":"ACTIVE","8":"ACTIVE"},"status":"ACTIVE"}`,
+		// 未知的枚举数值保留为数值,键值对中缺失的值取默认值
+		`{"status":5,"counts":{"n":null},"states":{"1":"UNKNOWN"}}`: `{"counts":{"n":0},"states":{"1":"UNKNOWN"},"status":5}`,
+	} {
+		data, err := reg.encodeJSON("shop.Order", []byte(req))
+		if err != nil {
+			t.Fatalf("%s: %s", req, err)
+		}
+		obj, err := reg.decode("shop.Order", data)
+		if err != nil {
+			t.Fatalf("%s: %s", req, err)
+		}
+		if got, _ := json.Marshal(obj); string(got) != want {
+			t.Fatalf("Unexpected round trip:\n got: %s\nwant: %s", got, want)
+		}
+	}
+	for _, req := range []string{`{"status":"NOPE"}`, `{"counts":[1]}`, `{"states":{"x":"ACTIVE"}}`} {
+		if _, err := reg.encodeJSON("shop.Order", []byte(req)); err == nil {
+			t.Fatalf("%s: expected an error!", req)
+		}
+	}
+}
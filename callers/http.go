@@ -0,0 +1,350 @@
+package callers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 调用结果的HTTP相关标签
+const (
+	TAG_HTTP_METHOD   = "http.method"   // 请求方法
+	TAG_HTTP_ENDPOINT = "http.endpoint" // 请求路径
+	TAG_HTTP_STATUS   = "http.status"   // 响应状态码
+	TAG_HTTP_PROTO    = "http.proto"    // 协议版本
+	TAG_HTTP_CONN     = "http.conn"     // 承载调用的连接
+)
+
+// HTTPConfig 表示HTTP调用器的配置
+// URL、Header的值和Body均为text/template模板,可使用的数据见TemplateData
+type HTTPConfig struct {
+	Method           string              // 请求方法,默认为GET
+	URL              string              // 请求地址模板
+	Header           map[string]string   // 请求头模板
+	Body             string              // 请求体模板
+	MaxIdleConns     int                 // 每个主机保持的空闲连接数,默认为100
+	DisableKeepAlive bool                // 是否禁用连接复用
+	SuccessStatus    []int               // 视为成功的状态码,默认为全部2xx
+	StatusCodes      map[int]lib.RetCode // 状态码到结果代码的显式映射,优先于默认映射
+	BodyContains     string              // 成功的响应体必须包含的内容
+	MaxBodySize      int64               // 可读取的最大响应体长度,超过时调用返回错误,默认为16MB
+	Transport        http.RoundTripper   // 自定义传输层,为nil时依据上述配置创建
+}
+
+// httpMaxBody 表示默认可读取的最大响应体长度
+const httpMaxBody = 16 << 20
+
+// TemplateData 表示渲染请求模板时可使用的数据
+type TemplateData struct {
+	ID   int64 // 请求ID
+	Seq  int64 // 请求序号,从1开始
+	Time int64 // 当前时间戳,单位:纳秒
+}
+
+// templateFuncs 表示请求模板中可使用的函数,即lib.TemplateFuncs提供的值生成函数
+var templateFuncs = lib.TemplateFuncs()
+
+// httpReq 表示序列化在原生请求中的HTTP请求
+type httpReq struct {
+	Method string
+	URL    string
+	Header map[string]string
+	Body   []byte
+}
+
+// httpResp 表示序列化在原生响应中的HTTP响应
+type httpResp struct {
+	Status int
+	Proto  string
+	Conn   string
+	Header http.Header
+	Body   []byte
+}
+
+// httpConn 表示一组可供调用使用的客户端连接
+type httpConn struct {
+	name    string        // 名称,为空时不记录连接标签
+	client  *http.Client  // 客户端
+	tickets lib.GoTickets // 并发流票池,为nil时不限制
+}
+
+// HTTPCaller 表示HTTP调用器,由NewHTTPCaller(HTTP/1.1)或NewHTTP2Caller创建
+type HTTPCaller struct {
+	cfg         HTTPConfig                    // 配置
+	feederNames                               // 请求模板中使用的已注册数据源
+	conns       []*httpConn                   // 客户端连接
+	next        uint64                        // 下一个使用的连接序号
+	urlTmpl     *template.Template            // 请求地址模板
+	hdrTmpl     map[string]*template.Template // 请求头模板
+	bodyTpl     *template.Template            // 请求体模板
+	seq         int64                         // 请求序号
+}
+
+// NewHTTPCaller 新建一个HTTP调用器
+func NewHTTPCaller(cfg HTTPConfig) (lib.Caller, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("Invalid HTTP URL!")
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodGet
+	}
+	if cfg.MaxIdleConns <= 0 {
+		cfg.MaxIdleConns = 100
+	}
+	caller, err := newHTTPCaller(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := cfg.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConns:        cfg.MaxIdleConns,
+			MaxIdleConnsPerHost: cfg.MaxIdleConns,
+			DisableKeepAlives:   cfg.DisableKeepAlive,
+			IdleConnTimeout:     90 * time.Second,
+		}
+	}
+	caller.conns = []*httpConn{{client: &http.Client{Transport: transport}}}
+	return caller, nil
+}
+
+// newHTTPCaller 依据配置解析请求模板,但不创建客户端连接
+func newHTTPCaller(cfg HTTPConfig) (*HTTPCaller, error) {
+	if cfg.MaxBodySize < 0 {
+		return nil, fmt.Errorf("Invalid HTTP max body size: %d!", cfg.MaxBodySize)
+	}
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = httpMaxBody
+	}
+	caller := &HTTPCaller{cfg: cfg, hdrTmpl: make(map[string]*template.Template)}
+	var err error
+	if caller.urlTmpl, err = parseTemplate("url", cfg.URL); err != nil {
+		return nil, err
+	}
+	if caller.bodyTpl, err = parseTemplate("body", cfg.Body); err != nil {
+		return nil, err
+	}
+	for k, v := range cfg.Header {
+		if caller.hdrTmpl[k], err = parseTemplate("header "+k, v); err != nil {
+			return nil, err
+		}
+	}
+	tmpls := []*template.Template{caller.urlTmpl, caller.bodyTpl}
+	for _, tmpl := range caller.hdrTmpl {
+		tmpls = append(tmpls, tmpl)
+	}
+	caller.feederNames = templateFeeders(tmpls...)
+	return caller, nil
+}
+
+// parseTemplate 解析请求模板
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s template: %s", name, err)
+	}
+	return tmpl, nil
+}
+
+// renderer 依次渲染请求模板,并记录第一个渲染错误
+// 同一renderer渲染的模板属于同一请求,其中的feed函数对同名数据源只读取一次
+type renderer struct {
+	data  *TemplateData
+	err   error
+	feeds map[string]lib.Record // 本次请求已读取的数据源记录
+}
+
+// render 渲染请求模板,已发生错误时不再渲染并返回空字符串
+func (r *renderer) render(tmpl *template.Template) string {
+	if r.err != nil {
+		return ""
+	}
+	if usesFeed(tmpl) {
+		//在副本上以本次请求的feed函数渲染,不影响并发的其他请求
+		clone, err := tmpl.Clone()
+		if err != nil {
+			r.err = err
+			return ""
+		}
+		tmpl = clone.Funcs(template.FuncMap{"feed": r.feed})
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r.data); err != nil {
+		r.err = err
+		return ""
+	}
+	return buf.String()
+}
+
+// BuildReq 依据模板构建一个请求
+func (caller *HTTPCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	r := &renderer{data: data}
+	hreq := httpReq{
+		Method: caller.cfg.Method,
+		URL:    r.render(caller.urlTmpl),
+		Header: make(map[string]string, len(caller.hdrTmpl)),
+		Body:   []byte(r.render(caller.bodyTpl)),
+	}
+	for k, tmpl := range caller.hdrTmpl {
+		hreq.Header[k] = r.render(tmpl)
+	}
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	mBytes, err := json.Marshal(hreq)
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: data.ID, Req: mBytes}
+}
+
+// EditRequest 以edit改写请求体和请求头
+func (caller *HTTPCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	var hreq httpReq
+	if err := json.Unmarshal(req, &hreq); err != nil {
+		return nil, err
+	}
+	if hreq.Header == nil {
+		hreq.Header = make(map[string]string)
+	}
+	body, err := edit(hreq.Body, hreq.Header)
+	if err != nil {
+		return nil, err
+	}
+	hreq.Body = body
+	return json.Marshal(hreq)
+}
+
+// Call 发送一次HTTP请求,响应体会被完整读取以便复用连接
+func (caller *HTTPCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var hreq httpReq
+	if err := json.Unmarshal(req, &hreq); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	request, err := http.NewRequest(hreq.Method, hreq.URL, bytes.NewReader(hreq.Body))
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	for k, v := range hreq.Header {
+		if strings.EqualFold(k, "Host") {
+			request.Host = v
+			continue
+		}
+		request.Header.Set(k, v)
+	}
+	conn := caller.conns[0]
+	if len(caller.conns) > 1 {
+		conn = caller.conns[atomic.AddUint64(&caller.next, 1)%uint64(len(caller.conns))]
+	}
+	if conn.tickets != nil {
+		// 等待并发流的时间计入超时
+		if err := conn.tickets.TakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("Timeout waiting for a stream on %s! (%s)", conn.name, err)
+		}
+		defer conn.tickets.Return()
+	}
+	response, err := conn.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, caller.cfg.MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > caller.cfg.MaxBodySize {
+		return nil, fmt.Errorf("HTTP response body too large! (max=%d)", caller.cfg.MaxBodySize)
+	}
+	return json.Marshal(httpResp{
+		Status: response.StatusCode,
+		Proto:  response.Proto,
+		Conn:   conn.name,
+		Header: response.Header,
+		Body:   body,
+	})
+}
+
+// CheckResp 依据状态码和响应体检查响应内容
+func (caller *HTTPCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var hreq httpReq
+	if err := json.Unmarshal(rawReq.Req, &hreq); err != nil {
+		result.Code = lib.RET_CODE_FATAL_CALL
+		result.Msg = fmt.Sprintf("Incorrectly formatted Req: %s!", string(rawReq.Req))
+		return &result
+	}
+	result.SetTag(TAG_HTTP_METHOD, hreq.Method)
+	if u, err := url.Parse(hreq.URL); err == nil {
+		result.SetTag(TAG_HTTP_ENDPOINT, u.Path)
+	}
+
+	var hresp httpResp
+	if err := json.Unmarshal(rawResp.Resp, &hresp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	result.SetTag(TAG_HTTP_STATUS, strconv.Itoa(hresp.Status))
+	result.SetTag(TAG_HTTP_PROTO, hresp.Proto)
+	if hresp.Conn != "" {
+		result.SetTag(TAG_HTTP_CONN, hresp.Conn)
+	}
+	result.Code = caller.statusCode(hresp.Status)
+	if result.Code != lib.RET_CODE_SUCCESS {
+		result.Msg = fmt.Sprintf("Unexpected status: %d %s!", hresp.Status, http.StatusText(hresp.Status))
+		return &result
+	}
+	if caller.cfg.BodyContains != "" && !bytes.Contains(hresp.Body, []byte(caller.cfg.BodyContains)) {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Missing expected content in body: %q!", caller.cfg.BodyContains)
+		return &result
+	}
+	result.Msg = fmt.Sprintf("Success. (%d %s)", hresp.Status, http.StatusText(hresp.Status))
+	return &result
+}
+
+// statusCode 把HTTP状态码映射为结果代码
+func (caller *HTTPCaller) statusCode(status int) lib.RetCode {
+	if code, ok := caller.cfg.StatusCodes[status]; ok {
+		return code
+	}
+	if len(caller.cfg.SuccessStatus) > 0 {
+		for _, s := range caller.cfg.SuccessStatus {
+			if s == status {
+				return lib.RET_CODE_SUCCESS
+			}
+		}
+	} else if status >= 200 && status < 300 {
+		return lib.RET_CODE_SUCCESS
+	}
+	if status >= 500 {
+		return lib.RET_CODE_ERROR_CALEE
+	}
+	return lib.RET_CODE_ERROR_RESPONSE
+}
@@ -0,0 +1,78 @@
+package callers
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"loadgen/lib"
+)
+
+// HTTP2Config 表示HTTP/2调用器的配置
+type HTTP2Config struct {
+	HTTPConfig
+	Cleartext      bool        // 是否使用明文的h2c,否则使用基于TLS的HTTP/2
+	Conns          int         // 连接数,默认为1
+	StreamsPerConn uint32      // 每个连接上的最大并发流数,默认为100
+	TLSConfig      *tls.Config // TLS配置,仅在非h2c模式下使用
+}
+
+// NewHTTP2Caller 新建一个HTTP/2调用器
+// 调用以轮转的方式分布在固定数量的连接上,每个连接上的并发流数受StreamsPerConn限制,
+// 每个调用结果都带有标识所用连接的标签
+func NewHTTP2Caller(cfg HTTP2Config) (lib.Caller, error) {
+	if cfg.Transport != nil {
+		return nil, errors.New("Custom transport is not supported by the HTTP/2 caller!")
+	}
+	if cfg.Cleartext && strings.HasPrefix(cfg.URL, "https://") {
+		return nil, errors.New("h2c requires an http:// URL!")
+	}
+	if !cfg.Cleartext && strings.HasPrefix(cfg.URL, "http://") {
+		return nil, errors.New("HTTP/2 over TLS requires an https:// URL!")
+	}
+	if cfg.Conns <= 0 {
+		cfg.Conns = 1
+	}
+	if cfg.StreamsPerConn == 0 {
+		cfg.StreamsPerConn = 100
+	}
+	caller, err := newHTTPCaller(cfg.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < cfg.Conns; i++ {
+		tickets, err := lib.NewGoTickets(cfg.StreamsPerConn)
+		if err != nil {
+			return nil, err
+		}
+		caller.conns = append(caller.conns, &httpConn{
+			name:    fmt.Sprintf("conn-%d", i),
+			client:  &http.Client{Transport: newHTTP2Transport(cfg.Cleartext, cfg.TLSConfig)},
+			tickets: tickets,
+		})
+	}
+	return caller, nil
+}
+
+// newHTTP2Transport 新建一个只持有单个HTTP/2连接的传输层
+func newHTTP2Transport(cleartext bool, tlsConfig *tls.Config) *http.Transport {
+	var protocols http.Protocols
+	if cleartext {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP2(true)
+	}
+	return &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		Protocols:       &protocols,
+		TLSClientConfig: tlsConfig,
+		MaxConnsPerHost: 1,
+		IdleConnTimeout: 90 * time.Second,
+		HTTP2: &http.HTTP2Config{
+			StrictMaxConcurrentRequests: true,
+		},
+	}
+}
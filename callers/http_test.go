@@ -0,0 +1,295 @@
+package callers
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+)
+
+// call 依次执行构建请求、调用和检查响应
+func call(caller lib.Caller, timeoutNS time.Duration) *lib.CallResult {
+	rawReq := caller.BuildReq()
+	if rawReq.Err != nil {
+		return &lib.CallResult{ID: rawReq.ID, Code: lib.RET_CODE_ERROR_CALL, Msg: rawReq.Err.Error()}
+	}
+	start := time.Now()
+	resp, err := caller.Call(rawReq.Req, timeoutNS)
+	rawResp := lib.RawResp{ID: rawReq.ID, Resp: resp, Err: err, Elapse: time.Since(start)}
+	if err != nil {
+		return &lib.CallResult{ID: rawReq.ID, Code: lib.RET_CODE_ERROR_CALL, Msg: err.Error()}
+	}
+	return caller.CheckResp(rawReq, rawResp)
+}
+
+func TestHTTPCaller(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/echo":
+			if r.Header.Get("X-Seq") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write(body)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	caller, err := NewHTTPCaller(HTTPConfig{
+		Method:       http.MethodPost,
+		URL:          server.URL + "/echo?id={{.ID}}",
+		Header:       map[string]string{"X-Seq": "{{.Seq}}"},
+		Body:         `{"seq":{{.Seq}},"value":{{randInt 1 10}}}`,
+		BodyContains: `"seq":`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		result := call(caller, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+		if result.Tag(TAG_HTTP_ENDPOINT) != "/echo" || result.Tag(TAG_HTTP_STATUS) != "200" {
+			t.Fatalf("Unexpected tags: %v", result.Tags)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("Connections were not reused! (count=%d)", n)
+	}
+
+	caller, _ = NewHTTPCaller(HTTPConfig{URL: server.URL + "/fail"})
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_ERROR_CALEE {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	caller, _ = NewHTTPCaller(HTTPConfig{
+		URL:         server.URL + "/fail",
+		StatusCodes: map[int]lib.RetCode{500: lib.RET_CODE_SUCCESS},
+	})
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+}
+
+func TestHTTP2Caller(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	// h2c
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+	caller, err := NewHTTP2Caller(HTTP2Config{
+		HTTPConfig: HTTPConfig{URL: server.URL + "/"},
+		Cleartext:  true,
+		Conns:      2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connTags := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		result := call(caller, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS || result.Tag(TAG_HTTP_PROTO) != "HTTP/2.0" {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+		connTags[result.Tag(TAG_HTTP_CONN)] = true
+	}
+	if len(connTags) != 2 {
+		t.Fatalf("Unexpected connections: %v", connTags)
+	}
+
+	// 基于TLS的HTTP/2
+	tlsServer := httptest.NewUnstartedServer(handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsServer.Certificate())
+	caller, err = NewHTTP2Caller(HTTP2Config{
+		HTTPConfig: HTTPConfig{URL: tlsServer.URL + "/"},
+		TLSConfig:  &tls.Config{RootCAs: pool},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+}
+
+func TestHTTPCallerLimits(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+	defer close(release)
+
+	// 并发流用尽时等待流的时间计入超时
+	caller, err := NewHTTP2Caller(HTTP2Config{
+		HTTPConfig:     HTTPConfig{URL: server.URL + "/slow"},
+		Cleartext:      true,
+		StreamsPerConn: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go call(caller, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	begin := time.Now()
+	result := call(caller, 100*time.Millisecond)
+	if result.Code != lib.RET_CODE_ERROR_CALL || !strings.Contains(result.Msg, "Timeout waiting for a stream") {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("Call did not honour the timeout! (elapsed=%v)", elapsed)
+	}
+
+	// 响应体超过MaxBodySize时返回错误
+	caller, _ = NewHTTPCaller(HTTPConfig{URL: server.URL + "/", MaxBodySize: 99})
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_ERROR_CALL || !strings.Contains(result.Msg, "too large") {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	caller, _ = NewHTTPCaller(HTTPConfig{URL: server.URL + "/", MaxBodySize: 100})
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	if _, err := NewHTTPCaller(HTTPConfig{URL: server.URL + "/", MaxBodySize: -1}); err == nil {
+		t.Fatal("Expected an error with invalid max body size!")
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	httpCaller, err := NewHTTPCaller(HTTPConfig{URL: "http://127.0.0.1:1/{{.Missing}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpCaller, err := NewUDPCaller(UDPConfig{Addr: "127.0.0.1:1", Message: `{{weighted "a:0"}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, caller := range []lib.Caller{httpCaller, udpCaller} {
+		rawReq := caller.BuildReq()
+		if rawReq.Err == nil || rawReq.ID == 0 || rawReq.Req != nil {
+			t.Fatalf("%T: unexpected request: %v (err=%v)", caller, rawReq, rawReq.Err)
+		}
+	}
+}
+
+func TestFeedPerRequest(t *testing.T) {
+	registerFeeder(t, "http.users", []lib.Record{{"id": "a", "name": "x"}, {"id": "b", "name": "y"}}, lib.FEED_CIRCULAR)
+	caller, err := NewHTTPCaller(HTTPConfig{
+		URL:    `http://127.0.0.1:1/users/{{(feed "http.users").id}}`,
+		Header: map[string]string{"X-Name": `{{(feed "http.users").name}}`},
+		Body:   `{{$u := feed "http.users"}}{{$u.id}}-{{$u.name}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := caller.(lib.FeederUser).Feeders(); len(names) != 1 || names[0] != "http.users" {
+		t.Fatalf("Unexpected feeders: %v", names)
+	}
+	// URL、请求头和请求体在同一请求中使用同一条记录
+	for _, want := range []string{"a-x", "b-y", "a-x"} {
+		rawReq := caller.BuildReq()
+		var req httpReq
+		if err := json.Unmarshal(rawReq.Req, &req); err != nil {
+			t.Fatal(err)
+		}
+		id := strings.TrimPrefix(req.URL, "http://127.0.0.1:1/users/")
+		if string(req.Body) != want || id+"-"+req.Header["X-Name"] != want {
+			t.Fatalf("Unexpected request: url=%s, header=%v, body=%s (want %s)", req.URL, req.Header, req.Body, want)
+		}
+	}
+}
+
+func TestHTTPMiddlewares(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" || r.Header.Get("Traceparent") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(zr)
+		if r.Header.Get("X-Signature") != fmt.Sprintf("%d", len(body)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Trace", r.Header.Get("Traceparent"))
+		w.Write(body)
+	}))
+	defer server.Close()
+	caller, err := NewHTTPCaller(HTTPConfig{
+		Method:       http.MethodPost,
+		URL:          server.URL + "/echo",
+		Body:         `{"seq":{{.Seq}}}`,
+		BodyContains: `"seq":1`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 签名在压缩之前计算,载荷和头部经RequestEditor改写,HTTP信封保持有效
+	caller = lib.Chain(caller,
+		lib.TracingMiddleware(lib.TraceHeader("traceparent")),
+		lib.SigningMiddleware(func(payload []byte, header map[string]string) ([]byte, error) {
+			header["X-Signature"] = fmt.Sprintf("%d", len(payload))
+			return payload, nil
+		}),
+		lib.GzipMiddleware(),
+	)
+	result := call(caller, time.Second)
+	if result.Code != lib.RET_CODE_SUCCESS || result.Tag(lib.TAG_TRACE) == "" {
+		t.Fatalf("Unexpected result: %v (tags=%v)", result, result.Tags)
+	}
+
+	// 未实现RequestEditor的调用器使用改写请求的中间件时报告错误而不是发出损坏的请求
+	redis, err := newRedisCaller(RedisConfig{Addr: "127.0.0.1:1", Commands: []string{"PING"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := lib.Chain(redis, lib.GzipMiddleware())
+	if _, err := wrapped.Call(wrapped.BuildReq().Req, time.Second); err == nil || !strings.Contains(err.Error(), "can not edit requests") {
+		t.Fatalf("Expected an edit error, got: %v", err)
+	}
+}
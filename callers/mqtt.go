@@ -0,0 +1,443 @@
+package callers
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// MQTT 3.1.1控制报文类型
+const (
+	MQTT_CONNECT    = 1
+	MQTT_CONNACK    = 2
+	MQTT_PUBLISH    = 3
+	MQTT_PUBACK     = 4
+	MQTT_PUBREC     = 5
+	MQTT_PUBREL     = 6
+	MQTT_PUBCOMP    = 7
+	MQTT_SUBSCRIBE  = 8
+	MQTT_SUBACK     = 9
+	MQTT_PINGREQ    = 12
+	MQTT_PINGRESP   = 13
+	MQTT_DISCONNECT = 14
+)
+
+// 调用结果的MQTT相关标签
+const (
+	TAG_MQTT_QOS = "mqtt.qos" // 服务质量等级
+	TAG_MQTT_ACK = "mqtt.ack" // 从发布到收到确认的耗时
+	TAG_MQTT_E2E = "mqtt.e2e" // 从发布到订阅方收到消息的耗时
+)
+
+// mqttMaxPacket 表示默认可接收的最大报文长度
+const mqttMaxPacket = 16 << 20
+
+// MQTTConfig 表示MQTT发布调用器的配置
+type MQTTConfig struct {
+	Addr           string // Broker地址,形如host:port
+	ClientID       string // 客户端标识,为空时自动生成
+	Username       string // 用户名,为空时不提供
+	Password       string // 密码
+	Topic          string // 主题模板,可使用的数据见TemplateData
+	Payload        string // 消息模板,端到端模式下每条消息的内容须唯一,如包含{{.ID}}
+	QoS            byte   // 服务质量等级,取值为0、1或2
+	EndToEnd       bool   // 是否等待订阅方收到消息,用于测量端到端延迟
+	SubscribeTopic string // 端到端模式下订阅的主题过滤器,为空时使用Topic
+	MaxPacketSize  int    // 可接收的最大报文长度,超过时断开连接,默认为16MB
+}
+
+// mqttReq 表示序列化在原生请求中的MQTT消息
+type mqttReq struct {
+	Topic   string
+	Payload []byte
+}
+
+// mqttResp 表示序列化在原生响应中的发布结果
+type mqttResp struct {
+	Ack time.Duration // 从发布到收到确认的耗时,QoS 0时为写出的耗时
+	E2E time.Duration // 从发布到订阅方收到消息的耗时,非端到端模式时为0
+}
+
+// mqttConn 表示一个MQTT连接,由读协程分发确认报文和收到的消息
+type mqttConn struct {
+	conn      net.Conn
+	maxPacket int // 可接收的最大报文长度
+	writeMu   sync.Mutex
+	acks      *waiters // 键形如"PUBACK:1"
+	msgs      *waiters // 键为消息内容,用于端到端模式
+}
+
+// MQTTCaller 表示MQTT发布调用器
+// 发布和订阅各使用一个长连接,连接断开后调用均返回错误,需重新创建调用器
+type MQTTCaller struct {
+	cfg         MQTTConfig
+	feederNames // 请求模板中使用的已注册数据源
+	topic       *template.Template
+	payload     *template.Template
+	pub         *mqttConn
+	sub         *mqttConn
+	seq         int64  // 请求序号
+	id          uint32 // 报文标识
+}
+
+// NewMQTTCaller 新建一个MQTT发布调用器并建立连接
+func NewMQTTCaller(cfg MQTTConfig) (lib.Caller, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("Invalid MQTT broker address!")
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("Invalid MQTT QoS: %d!", cfg.QoS)
+	}
+	if cfg.MaxPacketSize < 0 {
+		return nil, fmt.Errorf("Invalid MQTT max packet size: %d!", cfg.MaxPacketSize)
+	}
+	if cfg.MaxPacketSize == 0 {
+		cfg.MaxPacketSize = mqttMaxPacket
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "loadgen-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	if cfg.SubscribeTopic == "" {
+		cfg.SubscribeTopic = cfg.Topic
+	}
+	caller := &MQTTCaller{cfg: cfg}
+	var err error
+	if caller.topic, err = parseTemplate("topic", cfg.Topic); err != nil {
+		return nil, err
+	}
+	if caller.payload, err = parseTemplate("payload", cfg.Payload); err != nil {
+		return nil, err
+	}
+	caller.feederNames = templateFeeders(caller.topic, caller.payload)
+	if caller.pub, err = caller.connect(cfg.ClientID); err != nil {
+		return nil, err
+	}
+	if cfg.EndToEnd {
+		if caller.sub, err = caller.connect(cfg.ClientID + "-sub"); err != nil {
+			caller.pub.conn.Close()
+			return nil, err
+		}
+		if err := caller.subscribe(); err != nil {
+			caller.Close()
+			return nil, err
+		}
+	}
+	return caller, nil
+}
+
+// connect 建立连接并完成CONNECT/CONNACK握手
+func (caller *MQTTCaller) connect(clientID string) (*mqttConn, error) {
+	conn, err := net.DialTimeout("tcp", caller.cfg.Addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	flags := byte(0x02) // 清除会话
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 4, 0, 0, 0) // 协议级别4,标志位稍后填写,不启用心跳
+	payload := appendMQTTString(nil, clientID)
+	if caller.cfg.Username != "" {
+		flags |= 0x80 | 0x40
+		payload = appendMQTTString(payload, caller.cfg.Username)
+		payload = appendMQTTString(payload, caller.cfg.Password)
+	}
+	body[7] = flags
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(mqttPacket(MQTT_CONNECT<<4, append(body, payload...))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	header, ack, err := readMQTTPacket(r, caller.cfg.MaxPacketSize)
+	if err == nil && (header>>4 != MQTT_CONNACK || len(ack) != 2) {
+		err = fmt.Errorf("Unexpected MQTT packet: %d", header>>4)
+	}
+	if err == nil && ack[1] != 0 {
+		err = fmt.Errorf("MQTT connection refused! (code=%d)", ack[1])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	mc := &mqttConn{conn: conn, maxPacket: caller.cfg.MaxPacketSize, acks: newWaiters(), msgs: newWaiters()}
+	go mc.readLoop(r)
+	return mc, nil
+}
+
+// subscribe 在订阅连接上以QoS 0订阅主题过滤器
+func (caller *MQTTCaller) subscribe() error {
+	id := caller.nextID()
+	key := "SUBACK:" + strconv.Itoa(int(id))
+	ch, err := caller.sub.acks.add(key)
+	if err != nil {
+		return err
+	}
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = appendMQTTString(body, caller.cfg.SubscribeTopic)
+	body = append(body, 0)
+	if err := caller.sub.write(mqttPacket(MQTT_SUBSCRIBE<<4|0x02, body)); err != nil {
+		return err
+	}
+	data, err := caller.sub.acks.wait(key, ch, time.Now().Add(5*time.Second))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || data[0] == 0x80 {
+		return fmt.Errorf("MQTT subscription rejected: %s", caller.cfg.SubscribeTopic)
+	}
+	return nil
+}
+
+// nextID 返回下一个非零的报文标识
+func (caller *MQTTCaller) nextID() uint16 {
+	return uint16(atomic.AddUint32(&caller.id, 1)%65535 + 1)
+}
+
+// Close 断开连接
+func (caller *MQTTCaller) Close() {
+	for _, mc := range []*mqttConn{caller.pub, caller.sub} {
+		if mc != nil {
+			mc.write(mqttPacket(MQTT_DISCONNECT<<4, nil))
+			mc.conn.Close()
+		}
+	}
+}
+
+// BuildReq 依据模板构建一条消息
+func (caller *MQTTCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	r := &renderer{data: data}
+	mreq := mqttReq{
+		Topic:   r.render(caller.topic),
+		Payload: []byte(r.render(caller.payload)),
+	}
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	req, err := json.Marshal(mreq)
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: data.ID, Req: req}
+}
+
+// EditRequest 以edit改写消息内容,协议不支持头部
+func (caller *MQTTCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	var msg mqttReq
+	if err := json.Unmarshal(req, &msg); err != nil {
+		return nil, err
+	}
+	payload, err := edit(msg.Payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = payload
+	return json.Marshal(msg)
+}
+
+// Call 发布一条消息,并按QoS等待确认;端到端模式下再等待订阅方收到该消息
+func (caller *MQTTCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var msg mqttReq
+	if err := json.Unmarshal(req, &msg); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	deadline := start.Add(timeoutNS)
+	var delivery chan waitResult
+	if caller.sub != nil {
+		var err error
+		if delivery, err = caller.sub.msgs.add(string(msg.Payload)); err != nil {
+			return nil, err
+		}
+		defer caller.sub.msgs.remove(string(msg.Payload))
+	}
+	if err := caller.publish(msg, deadline); err != nil {
+		return nil, err
+	}
+	resp := mqttResp{Ack: time.Since(start)}
+	if delivery != nil {
+		if _, err := caller.sub.msgs.wait(string(msg.Payload), delivery, deadline); err != nil {
+			return nil, err
+		}
+		resp.E2E = time.Since(start)
+	}
+	return json.Marshal(resp)
+}
+
+// publish 发出PUBLISH报文并完成QoS要求的确认流程
+func (caller *MQTTCaller) publish(msg mqttReq, deadline time.Time) error {
+	qos := caller.cfg.QoS
+	body := appendMQTTString(nil, msg.Topic)
+	var id uint16
+	if qos > 0 {
+		id = caller.nextID()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, msg.Payload...)
+	packet := mqttPacket(MQTT_PUBLISH<<4|qos<<1, body)
+	if qos == 0 {
+		return caller.pub.write(packet)
+	}
+	idStr := strconv.Itoa(int(id))
+	first := "PUBACK:" + idStr
+	if qos == 2 {
+		first = "PUBREC:" + idStr
+	}
+	ch, err := caller.pub.acks.add(first)
+	if err != nil {
+		return err
+	}
+	if err := caller.pub.write(packet); err != nil {
+		caller.pub.acks.remove(first)
+		return err
+	}
+	if _, err := caller.pub.acks.wait(first, ch, deadline); err != nil || qos == 1 {
+		return err
+	}
+	comp := "PUBCOMP:" + idStr
+	if ch, err = caller.pub.acks.add(comp); err != nil {
+		return err
+	}
+	if err := caller.pub.write(mqttPacket(MQTT_PUBREL<<4|0x02, binary.BigEndian.AppendUint16(nil, id))); err != nil {
+		caller.pub.acks.remove(comp)
+		return err
+	}
+	_, err = caller.pub.acks.wait(comp, ch, deadline)
+	return err
+}
+
+// CheckResp 检查发布结果
+func (caller *MQTTCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var resp mqttResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	result.SetTag(TAG_MQTT_QOS, strconv.Itoa(int(caller.cfg.QoS)))
+	result.SetTag(TAG_MQTT_ACK, resp.Ack.String())
+	if caller.cfg.EndToEnd {
+		result.SetTag(TAG_MQTT_E2E, resp.E2E.String())
+	}
+	result.Code = lib.RET_CODE_SUCCESS
+	result.Msg = fmt.Sprintf("Success. (ack=%v)", resp.Ack)
+	return &result
+}
+
+// write 写出一个完整的报文
+func (mc *mqttConn) write(packet []byte) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+	_, err := mc.conn.Write(packet)
+	return err
+}
+
+// readLoop 持续读取报文,唤醒等待确认或消息的调用
+func (mc *mqttConn) readLoop(r *bufio.Reader) {
+	for {
+		header, body, err := readMQTTPacket(r, mc.maxPacket)
+		if err != nil {
+			mc.acks.fail(errConnBroken)
+			mc.msgs.fail(errConnBroken)
+			mc.conn.Close()
+			return
+		}
+		switch typ := header >> 4; typ {
+		case MQTT_PUBACK, MQTT_PUBREC, MQTT_PUBCOMP, MQTT_SUBACK:
+			if len(body) < 2 {
+				continue
+			}
+			names := map[byte]string{MQTT_PUBACK: "PUBACK", MQTT_PUBREC: "PUBREC", MQTT_PUBCOMP: "PUBCOMP", MQTT_SUBACK: "SUBACK"}
+			key := names[typ] + ":" + strconv.Itoa(int(binary.BigEndian.Uint16(body)))
+			mc.acks.resolve(key, body[2:])
+		case MQTT_PUBLISH:
+			if len(body) < 2 {
+				continue
+			}
+			n := int(binary.BigEndian.Uint16(body))
+			offset := 2 + n
+			if (header>>1)&0x03 > 0 {
+				offset += 2
+			}
+			if offset <= len(body) {
+				mc.msgs.resolve(string(body[offset:]), nil)
+			}
+		}
+	}
+}
+
+// mqttPacket 组装一个带固定报头的报文
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// appendMQTTString 追加一个以2字节长度开头的UTF-8字符串
+func appendMQTTString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// readMQTTPacket 读取一个报文,返回固定报头的首字节和剩余部分
+// 剩余部分长于max时返回错误
+func readMQTTPacket(r *bufio.Reader, max int) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("Malformed MQTT remaining length!")
+		}
+		multiplier *= 128
+	}
+	if n > max {
+		return 0, nil, fmt.Errorf("MQTT packet too large! (len=%d, max=%d)", n, max)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
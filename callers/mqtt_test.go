@@ -0,0 +1,78 @@
+package callers
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+func TestMQTTCaller(t *testing.T) {
+	broker := helper.NewMQTTBroker()
+	if err := broker.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	for qos := byte(0); qos <= 2; qos++ {
+		caller, err := NewMQTTCaller(MQTTConfig{
+			Addr:           broker.Addr().String(),
+			Topic:          "events/{{.Seq}}",
+			Payload:        `{"id":{{.ID}},"seq":{{.Seq}}}`,
+			QoS:            qos,
+			EndToEnd:       true,
+			SubscribeTopic: "events/+",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			result := call(caller, time.Second)
+			if result.Code != lib.RET_CODE_SUCCESS {
+				t.Fatalf("QoS %d: unexpected result: %v", qos, result)
+			}
+			if result.Tag(TAG_MQTT_QOS) != strconv.Itoa(int(qos)) || result.Tag(TAG_MQTT_E2E) == "" {
+				t.Fatalf("QoS %d: unexpected tags: %v", qos, result.Tags)
+			}
+		}
+		caller.(*MQTTCaller).Close()
+	}
+	if n := broker.Published(); n != 9 {
+		t.Fatalf("Unexpected number of published messages: %d", n)
+	}
+
+	// 没有订阅方时端到端模式超时
+	caller, err := NewMQTTCaller(MQTTConfig{
+		Addr:           broker.Addr().String(),
+		Topic:          "events/1",
+		Payload:        "{{.ID}}",
+		QoS:            1,
+		EndToEnd:       true,
+		SubscribeTopic: "other/#",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.(*MQTTCaller).Close()
+	if _, err := caller.Call(caller.BuildReq().Req, 50*time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout without matching subscription!")
+	}
+}
+
+func TestReadMQTTPacketLimit(t *testing.T) {
+	packet := mqttPacket(MQTT_PUBLISH<<4, make([]byte, 200))
+	if _, body, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)), 200); err != nil || len(body) != 200 {
+		t.Fatalf("Unexpected packet: %d bytes (err=%v)", len(body), err)
+	}
+	// 长度超过上限时不分配报文内容
+	if _, _, err := readMQTTPacket(bufio.NewReader(bytes.NewReader(packet)), 199); err == nil {
+		t.Fatal("Expected an error with oversized packet!")
+	}
+	if _, err := NewMQTTCaller(MQTTConfig{Addr: "127.0.0.1:1883", MaxPacketSize: -1}); err == nil {
+		t.Fatal("Expected an error with invalid max packet size!")
+	}
+}
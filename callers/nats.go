@@ -0,0 +1,399 @@
+package callers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// NATS调用器的模式
+const (
+	NATS_MODE_PUBLISH = "publish" // 发布消息,以PING/PONG往返确认服务器已处理
+	NATS_MODE_REQUEST = "request" // 请求-回复,等待响应方的回复
+)
+
+// 调用结果的NATS相关标签
+const (
+	TAG_NATS_MODE = "nats.mode" // 调用模式
+	TAG_NATS_ACK  = "nats.ack"  // 发布模式下从发布到收到PONG的耗时
+	TAG_NATS_E2E  = "nats.e2e"  // 从发布到订阅方收到消息或收到回复的耗时
+)
+
+// natsMaxPayload 表示服务器的INFO未声明max_payload时使用的消息长度上限,与NATS服务器的默认值相同
+const natsMaxPayload = 1 << 20
+
+// NATSConfig 表示NATS调用器的配置
+type NATSConfig struct {
+	Addr             string // 服务器地址,形如host:port
+	Subject          string // 主题模板,可使用的数据见TemplateData
+	Payload          string // 消息模板,端到端模式下每条消息的内容须唯一,如包含{{.ID}}
+	Mode             string // 调用模式,默认为NATS_MODE_PUBLISH
+	EndToEnd         bool   // 发布模式下是否等待订阅方收到消息,用于测量端到端延迟
+	SubscribeSubject string // 端到端模式下订阅的主题,可使用*和>通配符,为空时使用Subject
+	Token            string // 认证令牌,为空时不提供
+}
+
+// natsReq 表示序列化在原生请求中的NATS消息
+type natsReq struct {
+	Subject string
+	Payload []byte
+}
+
+// natsResp 表示序列化在原生响应中的调用结果
+type natsResp struct {
+	Ack   time.Duration // 发布模式下从发布到收到PONG的耗时
+	E2E   time.Duration // 从发布到订阅方收到消息或收到回复的耗时
+	Reply []byte        // 请求-回复模式下收到的回复
+}
+
+// NATSCaller 表示NATS调用器
+// 使用一个长连接,连接断开后调用均返回错误,需重新创建调用器
+type NATSCaller struct {
+	cfg         NATSConfig
+	feederNames // 请求模板中使用的已注册数据源
+	subject     *template.Template
+	payload     *template.Template
+	conn        net.Conn
+	writeMu     sync.Mutex
+	pongs       []chan waitResult // 等待PONG的调用,按发出PING的顺序排列
+	pongErr     error             // 连接断开的原因
+	replies     *waiters          // 键为回复主题或消息内容
+	inbox       string            // 回复主题的前缀
+	maxPayload  int               // 服务器的INFO声明的消息长度上限
+	seq         int64             // 请求序号
+	next        int64             // 下一个回复主题的序号
+}
+
+// NewNATSCaller 新建一个NATS调用器并建立连接
+func NewNATSCaller(cfg NATSConfig) (lib.Caller, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("Invalid NATS server address!")
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = NATS_MODE_PUBLISH
+	case NATS_MODE_PUBLISH, NATS_MODE_REQUEST:
+	default:
+		return nil, fmt.Errorf("Invalid NATS mode: %s!", cfg.Mode)
+	}
+	if cfg.SubscribeSubject == "" {
+		cfg.SubscribeSubject = cfg.Subject
+	}
+	caller := &NATSCaller{
+		cfg:     cfg,
+		replies: newWaiters(),
+		inbox:   "_INBOX." + strconv.FormatInt(time.Now().UnixNano(), 36),
+	}
+	var err error
+	if caller.subject, err = parseTemplate("subject", cfg.Subject); err != nil {
+		return nil, err
+	}
+	if caller.payload, err = parseTemplate("payload", cfg.Payload); err != nil {
+		return nil, err
+	}
+	caller.feederNames = templateFeeders(caller.subject, caller.payload)
+	if err := caller.connect(); err != nil {
+		return nil, err
+	}
+	return caller, nil
+}
+
+// connect 建立连接,完成INFO/CONNECT交互并订阅所需的主题
+func (caller *NATSCaller) connect() error {
+	conn, err := net.DialTimeout("tcp", caller.cfg.Addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("Unexpected NATS greeting: %q", strings.TrimSpace(line))
+	}
+	var info struct {
+		MaxPayload int `json:"max_payload"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		conn.Close()
+		return fmt.Errorf("Malformed NATS INFO: %s", err)
+	}
+	caller.maxPayload = info.MaxPayload
+	if caller.maxPayload <= 0 {
+		caller.maxPayload = natsMaxPayload
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "loadgen"}
+	if caller.cfg.Token != "" {
+		opts["auth_token"] = caller.cfg.Token
+	}
+	connect, _ := json.Marshal(opts)
+	cmds := "CONNECT " + string(connect) + "\r\n"
+	if caller.cfg.Mode == NATS_MODE_REQUEST {
+		cmds += "SUB " + caller.inbox + ".* 1\r\n"
+	} else if caller.cfg.EndToEnd {
+		cmds += "SUB " + caller.cfg.SubscribeSubject + " 2\r\n"
+	}
+	// 以PING/PONG确认服务器接受了连接和订阅
+	if _, err := io.WriteString(conn, cmds+"PING\r\n"); err != nil {
+		conn.Close()
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			return fmt.Errorf("NATS connection refused: %s", line)
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	caller.conn = conn
+	go caller.readLoop(r)
+	return nil
+}
+
+// Close 断开连接
+func (caller *NATSCaller) Close() {
+	caller.conn.Close()
+}
+
+// BuildReq 依据模板构建一条消息
+func (caller *NATSCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	r := &renderer{data: data}
+	nreq := natsReq{
+		Subject: r.render(caller.subject),
+		Payload: []byte(r.render(caller.payload)),
+	}
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	req, err := json.Marshal(nreq)
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: data.ID, Req: req}
+}
+
+// EditRequest 以edit改写消息内容,协议不支持头部
+func (caller *NATSCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	var msg natsReq
+	if err := json.Unmarshal(req, &msg); err != nil {
+		return nil, err
+	}
+	payload, err := edit(msg.Payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = payload
+	return json.Marshal(msg)
+}
+
+// Call 发布一条消息或发起一次请求-回复
+func (caller *NATSCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var msg natsReq
+	if err := json.Unmarshal(req, &msg); err != nil {
+		return nil, err
+	}
+	// 服务器对超长的消息回复-ERR并断开连接
+	if len(msg.Payload) > caller.maxPayload {
+		return nil, fmt.Errorf("NATS payload too large! (len=%d, max=%d)", len(msg.Payload), caller.maxPayload)
+	}
+	start := time.Now()
+	deadline := start.Add(timeoutNS)
+	if caller.cfg.Mode == NATS_MODE_REQUEST {
+		key := caller.inbox + "." + strconv.FormatInt(atomic.AddInt64(&caller.next, 1), 10)
+		ch, err := caller.replies.add(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := caller.write(natsPub(msg.Subject, key, msg.Payload), nil); err != nil {
+			caller.replies.remove(key)
+			return nil, err
+		}
+		reply, err := caller.replies.wait(key, ch, deadline)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(natsResp{E2E: time.Since(start), Reply: reply})
+	}
+
+	var delivery chan waitResult
+	key := string(msg.Payload)
+	if caller.cfg.EndToEnd {
+		var err error
+		if delivery, err = caller.replies.add(key); err != nil {
+			return nil, err
+		}
+		defer caller.replies.remove(key)
+	}
+	pong := make(chan waitResult, 1)
+	if err := caller.write(append(natsPub(msg.Subject, "", msg.Payload), "PING\r\n"...), pong); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case result := <-pong:
+		if result.err != nil {
+			return nil, result.err
+		}
+	case <-timer.C:
+		return nil, errors.New("Timeout waiting for PONG!")
+	}
+	resp := natsResp{Ack: time.Since(start)}
+	if delivery != nil {
+		if _, err := caller.replies.wait(key, delivery, deadline); err != nil {
+			return nil, err
+		}
+		resp.E2E = time.Since(start)
+	}
+	return json.Marshal(resp)
+}
+
+// write 写出协议内容,pong不为nil时表示内容以PING结尾,需排队等待PONG
+func (caller *NATSCaller) write(data []byte, pong chan waitResult) error {
+	caller.writeMu.Lock()
+	defer caller.writeMu.Unlock()
+	if pong != nil {
+		if caller.pongErr != nil {
+			return caller.pongErr
+		}
+		caller.pongs = append(caller.pongs, pong)
+	}
+	_, err := caller.conn.Write(data)
+	return err
+}
+
+// readLoop 持续读取服务器发来的协议内容,分发PONG、消息和回复
+func (caller *NATSCaller) readLoop(r *bufio.Reader) {
+	err := caller.read(r)
+	caller.conn.Close()
+	caller.replies.fail(errConnBroken)
+	caller.writeMu.Lock()
+	caller.pongErr = errConnBroken
+	pongs := caller.pongs
+	caller.pongs = nil
+	caller.writeMu.Unlock()
+	for _, ch := range pongs {
+		ch <- waitResult{err: fmt.Errorf("%s (%v)", errConnBroken, err)}
+	}
+}
+
+// read 读取协议内容直到出错
+func (caller *NATSCaller) read(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			caller.writeMu.Lock()
+			var ch chan waitResult
+			if len(caller.pongs) > 0 {
+				ch = caller.pongs[0]
+				caller.pongs = caller.pongs[1:]
+			}
+			caller.writeMu.Unlock()
+			if ch != nil {
+				ch <- waitResult{}
+			}
+		case line == "PING":
+			caller.write([]byte("PONG\r\n"), nil)
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return fmt.Errorf("Malformed NATS message: %q", line)
+			}
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("Malformed NATS message: %q", line)
+			}
+			if n > caller.maxPayload {
+				return fmt.Errorf("NATS message too large! (len=%d, max=%d)", n, caller.maxPayload)
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			payload = payload[:n]
+			if caller.cfg.Mode == NATS_MODE_REQUEST {
+				caller.replies.resolve(fields[1], payload)
+			} else {
+				caller.replies.resolve(string(payload), nil)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(line)
+		}
+	}
+}
+
+// natsPub 组装一条PUB协议内容
+func natsPub(subject, reply string, payload []byte) []byte {
+	buf := []byte("PUB " + subject + " ")
+	if reply != "" {
+		buf = append(buf, reply+" "...)
+	}
+	buf = strconv.AppendInt(buf, int64(len(payload)), 10)
+	buf = append(buf, "\r\n"...)
+	buf = append(buf, payload...)
+	return append(buf, "\r\n"...)
+}
+
+// CheckResp 检查调用结果
+func (caller *NATSCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var resp natsResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	result.SetTag(TAG_NATS_MODE, caller.cfg.Mode)
+	if caller.cfg.Mode == NATS_MODE_PUBLISH {
+		result.SetTag(TAG_NATS_ACK, resp.Ack.String())
+	}
+	if caller.cfg.Mode == NATS_MODE_REQUEST || caller.cfg.EndToEnd {
+		result.SetTag(TAG_NATS_E2E, resp.E2E.String())
+	}
+	result.Code = lib.RET_CODE_SUCCESS
+	if caller.cfg.Mode == NATS_MODE_REQUEST {
+		result.Msg = fmt.Sprintf("Success. (%s)", string(resp.Reply))
+	} else {
+		result.Msg = fmt.Sprintf("Success. (ack=%v)", resp.Ack)
+	}
+	return &result
+}
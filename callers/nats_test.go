@@ -0,0 +1,80 @@
+package callers
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+func TestNATSCaller(t *testing.T) {
+	server := helper.NewNATSServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.Respond("calc.upper", bytes.ToUpper)
+
+	publisher, err := NewNATSCaller(NATSConfig{
+		Addr:             server.Addr().String(),
+		Subject:          "events.{{.Seq}}",
+		Payload:          `{"id":{{.ID}}}`,
+		EndToEnd:         true,
+		SubscribeSubject: "events.>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.(*NATSCaller).Close()
+	for i := 0; i < 3; i++ {
+		result := call(publisher, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+		if result.Tag(TAG_NATS_ACK) == "" || result.Tag(TAG_NATS_E2E) == "" {
+			t.Fatalf("Unexpected tags: %v", result.Tags)
+		}
+	}
+
+	requester, err := NewNATSCaller(NATSConfig{
+		Addr:    server.Addr().String(),
+		Subject: "calc.upper",
+		Payload: "msg-{{.Seq}}",
+		Mode:    NATS_MODE_REQUEST,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requester.(*NATSCaller).Close()
+	result := call(requester, time.Second)
+	if result.Code != lib.RET_CODE_SUCCESS || result.Msg != "Success. (MSG-1)" {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	// 没有响应方时请求超时
+	noResponder, err := NewNATSCaller(NATSConfig{
+		Addr:    server.Addr().String(),
+		Subject: "calc.none",
+		Payload: "x",
+		Mode:    NATS_MODE_REQUEST,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer noResponder.(*NATSCaller).Close()
+	if _, err := noResponder.Call(noResponder.BuildReq().Req, 50*time.Millisecond); err == nil {
+		t.Fatal("Expected a timeout without responder!")
+	}
+	// 超过服务器声明的max_payload的消息不发出
+	req, _ := json.Marshal(natsReq{Subject: "calc.upper", Payload: make([]byte, 1<<20+1)})
+	if _, err := requester.Call(req, time.Second); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("Expected a payload error, got: %v", err)
+	}
+	if n := server.Published(); n != 5 {
+		t.Fatalf("Unexpected number of published messages: %d", n)
+	}
+}
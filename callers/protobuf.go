@@ -0,0 +1,987 @@
+package callers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// protobuf线路类型
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protobuf字段类型,取值与FieldDescriptorProto.Type一致
+const (
+	protoDouble   = 1
+	protoFloat    = 2
+	protoInt64    = 3
+	protoUint64   = 4
+	protoInt32    = 5
+	protoFixed64  = 6
+	protoFixed32  = 7
+	protoBool     = 8
+	protoString   = 9
+	protoGroup    = 10
+	protoMessage  = 11
+	protoBytes    = 12
+	protoUint32   = 13
+	protoEnum     = 14
+	protoSfixed32 = 15
+	protoSfixed64 = 16
+	protoSint32   = 17
+	protoSint64   = 18
+)
+
+// protoLabelRepeated 表示重复字段
+const protoLabelRepeated = 3
+
+// protoField 表示消息中的一个字段
+type protoField struct {
+	name     string // 字段名
+	jsonName string // JSON字段名
+	number   int    // 字段编号
+	label    int    // 标签
+	typ      int    // 类型
+	typeName string // 消息或枚举类型的全名,以"."开头
+}
+
+// protoMsgDesc 表示消息的描述
+type protoMsgDesc struct {
+	fullName string
+	fields   []*protoField
+	byName   map[string]*protoField
+	byNumber map[int]*protoField
+	mapEntry bool // 是否为映射字段自动生成的键值对消息,其键和值分别为1号和2号字段
+}
+
+// protoEnumDesc 表示枚举的描述
+type protoEnumDesc struct {
+	fullName string
+	byName   map[string]int32
+	byNumber map[int32]string
+}
+
+// protoMethod 表示服务中的一个方法
+type protoMethod struct {
+	path            string // 请求路径,如/pkg.Service/Method
+	input           string // 请求消息类型全名
+	output          string // 响应消息类型全名
+	clientStreaming bool   // 是否为客户端流
+	serverStreaming bool   // 是否为服务端流
+}
+
+// protoRegistry 表示从描述符集合中解析得到的消息和方法
+type protoRegistry struct {
+	messages map[string]*protoMsgDesc
+	enums    map[string]*protoEnumDesc
+	methods  map[string]*protoMethod // 键为"pkg.Service/Method"
+}
+
+// protoReader 表示protobuf线路格式的读取器
+type protoReader struct {
+	buf []byte
+}
+
+var errProtoTruncated = errors.New("protobuf: truncated message")
+
+// varint 读取一个变长整数
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errProtoTruncated
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+// next 读取下一个字段的编号和线路类型
+func (r *protoReader) next() (int, int, error) {
+	key, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(key >> 3), int(key & 7), nil
+}
+
+// bytes 读取一个长度前缀的字节串
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.buf)) < n {
+		return nil, errProtoTruncated
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b, nil
+}
+
+// fixed 读取size字节的小端定长整数
+func (r *protoReader) fixed(size int) (uint64, error) {
+	if len(r.buf) < size {
+		return 0, errProtoTruncated
+	}
+	var v uint64
+	if size == 4 {
+		v = uint64(binary.LittleEndian.Uint32(r.buf))
+	} else {
+		v = binary.LittleEndian.Uint64(r.buf)
+	}
+	r.buf = r.buf[size:]
+	return v, nil
+}
+
+// skip 跳过指定线路类型的字段值
+func (r *protoReader) skip(wt int) error {
+	var err error
+	switch wt {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed(8)
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		_, err = r.fixed(4)
+	default:
+		err = fmt.Errorf("protobuf: unsupported wire type %d", wt)
+	}
+	return err
+}
+
+// appendVarint 追加一个变长整数
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendTag 追加字段编号和线路类型
+func appendTag(b []byte, num int, wt int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wt))
+}
+
+// appendBytes 追加一个长度前缀的字节串字段
+func appendBytes(b []byte, num int, v []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// parseDescriptorSet 解析序列化的FileDescriptorSet
+func parseDescriptorSet(data []byte) (*protoRegistry, error) {
+	reg := &protoRegistry{
+		messages: make(map[string]*protoMsgDesc),
+		enums:    make(map[string]*protoEnumDesc),
+		methods:  make(map[string]*protoMethod),
+	}
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if num != 1 || wt != wireBytes {
+			if err := r.skip(wt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		file, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if err := reg.parseFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
+
+// parseFile 解析FileDescriptorProto
+func (reg *protoRegistry) parseFile(data []byte) error {
+	var pkg string
+	var messages, enums, services [][]byte
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return err
+		}
+		if wt != wireBytes {
+			if err := r.skip(wt); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 2:
+			pkg = string(b)
+		case 4:
+			messages = append(messages, b)
+		case 5:
+			enums = append(enums, b)
+		case 6:
+			services = append(services, b)
+		}
+	}
+	prefix := ""
+	if pkg != "" {
+		prefix = pkg + "."
+	}
+	for _, m := range messages {
+		if err := reg.parseMessage(m, prefix); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := reg.parseEnum(e, prefix); err != nil {
+			return err
+		}
+	}
+	for _, s := range services {
+		if err := reg.parseService(s, prefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMessage 解析DescriptorProto及其嵌套的消息和枚举
+func (reg *protoRegistry) parseMessage(data []byte, prefix string) error {
+	msg := &protoMsgDesc{byName: make(map[string]*protoField), byNumber: make(map[int]*protoField)}
+	var nested, enums [][]byte
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return err
+		}
+		if wt != wireBytes {
+			if err := r.skip(wt); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			msg.fullName = prefix + string(b)
+		case 2:
+			field, err := parseField(b)
+			if err != nil {
+				return err
+			}
+			msg.fields = append(msg.fields, field)
+		case 3:
+			nested = append(nested, b)
+		case 4:
+			enums = append(enums, b)
+		case 7:
+			if msg.mapEntry, err = parseMapEntryOption(b); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range msg.fields {
+		msg.byName[f.name] = f
+		msg.byName[f.jsonName] = f
+		msg.byNumber[f.number] = f
+	}
+	reg.messages[msg.fullName] = msg
+	for _, n := range nested {
+		if err := reg.parseMessage(n, msg.fullName+"."); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := reg.parseEnum(e, msg.fullName+"."); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMapEntryOption 从MessageOptions中读取map_entry
+func parseMapEntryOption(data []byte) (bool, error) {
+	var mapEntry bool
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return false, err
+		}
+		if num != 7 || wt != wireVarint {
+			if err := r.skip(wt); err != nil {
+				return false, err
+			}
+			continue
+		}
+		v, err := r.varint()
+		if err != nil {
+			return false, err
+		}
+		mapEntry = v != 0
+	}
+	return mapEntry, nil
+}
+
+// parseEnum 解析EnumDescriptorProto
+func (reg *protoRegistry) parseEnum(data []byte, prefix string) error {
+	enum := &protoEnumDesc{byName: make(map[string]int32), byNumber: make(map[int32]string)}
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return err
+		}
+		if wt != wireBytes {
+			if err := r.skip(wt); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			enum.fullName = prefix + string(b)
+		case 2:
+			name, number, err := parseEnumValue(b)
+			if err != nil {
+				return err
+			}
+			enum.byName[name] = number
+			// 存在别名时以第一个名称作为解码结果
+			if _, ok := enum.byNumber[number]; !ok {
+				enum.byNumber[number] = name
+			}
+		}
+	}
+	reg.enums[enum.fullName] = enum
+	return nil
+}
+
+// parseEnumValue 解析EnumValueDescriptorProto
+func parseEnumValue(data []byte) (string, int32, error) {
+	var name string
+	var number int32
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return "", 0, err
+		}
+		switch {
+		case num == 1 && wt == wireBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return "", 0, err
+			}
+			name = string(b)
+		case num == 2 && wt == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return "", 0, err
+			}
+			number = int32(v)
+		default:
+			if err := r.skip(wt); err != nil {
+				return "", 0, err
+			}
+		}
+	}
+	return name, number, nil
+}
+
+// parseField 解析FieldDescriptorProto
+func parseField(data []byte) (*protoField, error) {
+	field := &protoField{}
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case wt == wireBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			switch num {
+			case 1:
+				field.name = string(b)
+			case 6:
+				field.typeName = string(b)
+			case 10:
+				field.jsonName = string(b)
+			}
+		case wt == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return nil, err
+			}
+			switch num {
+			case 3:
+				field.number = int(v)
+			case 4:
+				field.label = int(v)
+			case 5:
+				field.typ = int(v)
+			}
+		default:
+			if err := r.skip(wt); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if field.jsonName == "" {
+		field.jsonName = lowerCamel(field.name)
+	}
+	if field.typ == protoGroup {
+		return nil, fmt.Errorf("protobuf: group field %s is not supported", field.name)
+	}
+	return field, nil
+}
+
+// lowerCamel 把下划线形式的字段名转换为JSON字段名
+func lowerCamel(name string) string {
+	var buf bytes.Buffer
+	upper := false
+	for _, c := range name {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// parseService 解析ServiceDescriptorProto
+func (reg *protoRegistry) parseService(data []byte, prefix string) error {
+	var name string
+	var methods []*protoMethod
+	var names []string
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return err
+		}
+		if wt != wireBytes {
+			if err := r.skip(wt); err != nil {
+				return err
+			}
+			continue
+		}
+		b, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		switch num {
+		case 1:
+			name = string(b)
+		case 2:
+			m, mName, err := parseMethod(b)
+			if err != nil {
+				return err
+			}
+			methods = append(methods, m)
+			names = append(names, mName)
+		}
+	}
+	for i, m := range methods {
+		key := prefix + name + "/" + names[i]
+		m.path = "/" + key
+		reg.methods[key] = m
+	}
+	return nil
+}
+
+// parseMethod 解析MethodDescriptorProto
+func parseMethod(data []byte) (*protoMethod, string, error) {
+	m := &protoMethod{}
+	var name string
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return nil, "", err
+		}
+		switch {
+		case wt == wireBytes:
+			b, err := r.bytes()
+			if err != nil {
+				return nil, "", err
+			}
+			switch num {
+			case 1:
+				name = string(b)
+			case 2:
+				m.input = strings.TrimPrefix(string(b), ".")
+			case 3:
+				m.output = strings.TrimPrefix(string(b), ".")
+			}
+		case wt == wireVarint:
+			v, err := r.varint()
+			if err != nil {
+				return nil, "", err
+			}
+			switch num {
+			case 5:
+				m.clientStreaming = v != 0
+			case 6:
+				m.serverStreaming = v != 0
+			}
+		default:
+			if err := r.skip(wt); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	return m, name, nil
+}
+
+// message 依据全名查找消息描述
+func (reg *protoRegistry) message(name string) (*protoMsgDesc, error) {
+	msg, ok := reg.messages[strings.TrimPrefix(name, ".")]
+	if !ok {
+		return nil, fmt.Errorf("protobuf: unknown message type %s", name)
+	}
+	return msg, nil
+}
+
+// enum 依据全名查找枚举描述
+func (reg *protoRegistry) enum(name string) (*protoEnumDesc, error) {
+	enum, ok := reg.enums[strings.TrimPrefix(name, ".")]
+	if !ok {
+		return nil, fmt.Errorf("protobuf: unknown enum type %s", name)
+	}
+	return enum, nil
+}
+
+// mapEntry 返回映射字段的键值对消息描述,field不是映射字段时返回nil
+func (reg *protoRegistry) mapEntry(field *protoField) *protoMsgDesc {
+	if field.typ != protoMessage || field.label != protoLabelRepeated {
+		return nil
+	}
+	msg, ok := reg.messages[strings.TrimPrefix(field.typeName, ".")]
+	if !ok || !msg.mapEntry {
+		return nil
+	}
+	return msg
+}
+
+// encodeJSON 把JSON编码的消息转换为protobuf线路格式
+func (reg *protoRegistry) encodeJSON(msgName string, data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	return reg.encode(msgName, obj)
+}
+
+// encode 编码一个消息
+func (reg *protoRegistry) encode(msgName string, obj map[string]interface{}) ([]byte, error) {
+	msg, err := reg.message(msgName)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	for key, v := range obj {
+		field, ok := msg.byName[key]
+		if !ok {
+			return nil, fmt.Errorf("protobuf: unknown field %s in %s", key, msg.fullName)
+		}
+		if v == nil {
+			continue
+		}
+		if entry := reg.mapEntry(field); entry != nil {
+			if b, err = reg.encodeMap(b, field, entry, v); err != nil {
+				return nil, fmt.Errorf("protobuf: field %s: %s", key, err)
+			}
+			continue
+		}
+		values := []interface{}{v}
+		if field.label == protoLabelRepeated {
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("protobuf: field %s expects an array", key)
+			}
+			values = list
+		}
+		for _, val := range values {
+			if b, err = reg.encodeValue(b, field, val); err != nil {
+				return nil, fmt.Errorf("protobuf: field %s: %s", key, err)
+			}
+		}
+	}
+	return b, nil
+}
+
+// encodeMap 把JSON对象编码为映射字段的一组键值对消息,键按字典序排列以保证编码结果稳定
+func (reg *protoRegistry) encodeMap(b []byte, field *protoField, entry *protoMsgDesc, v interface{}) ([]byte, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("object expected")
+	}
+	keyField, valueField := entry.byNumber[1], entry.byNumber[2]
+	if keyField == nil || valueField == nil {
+		return nil, fmt.Errorf("malformed map entry %s", entry.fullName)
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// JSON对象的键总是字符串,布尔类型的键需先转换
+		var key interface{} = k
+		if keyField.typ == protoBool {
+			flag, err := strconv.ParseBool(k)
+			if err != nil {
+				return nil, fmt.Errorf("invalid map key %q", k)
+			}
+			key = flag
+		}
+		kv, err := reg.encodeValue(nil, keyField, key)
+		if err != nil {
+			return nil, fmt.Errorf("map key %q: %s", k, err)
+		}
+		if obj[k] != nil {
+			if kv, err = reg.encodeValue(kv, valueField, obj[k]); err != nil {
+				return nil, fmt.Errorf("map value of %q: %s", k, err)
+			}
+		}
+		b = appendBytes(b, field.number, kv)
+	}
+	return b, nil
+}
+
+// encodeValue 编码一个字段值
+func (reg *protoRegistry) encodeValue(b []byte, field *protoField, v interface{}) ([]byte, error) {
+	switch field.typ {
+	case protoString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("string expected")
+		}
+		return appendBytes(b, field.number, []byte(s)), nil
+	case protoBytes:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("base64 string expected")
+		}
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			if raw, err = base64.URLEncoding.DecodeString(s); err != nil {
+				return nil, err
+			}
+		}
+		return appendBytes(b, field.number, raw), nil
+	case protoMessage:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("object expected")
+		}
+		sub, err := reg.encode(field.typeName, obj)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(b, field.number, sub), nil
+	case protoBool:
+		flag, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("boolean expected")
+		}
+		var n uint64
+		if flag {
+			n = 1
+		}
+		return appendVarint(appendTag(b, field.number, wireVarint), n), nil
+	case protoEnum:
+		// proto3 JSON映射中枚举值以名称表示,也接受数值
+		if name, ok := v.(string); ok {
+			enum, err := reg.enum(field.typeName)
+			if err != nil {
+				return nil, err
+			}
+			number, ok := enum.byName[name]
+			if !ok {
+				if _, err := strconv.ParseInt(name, 10, 32); err != nil {
+					return nil, fmt.Errorf("unknown value %s of enum %s", name, enum.fullName)
+				}
+			} else {
+				v = json.Number(strconv.Itoa(int(number)))
+			}
+		}
+	case protoDouble, protoFloat:
+		f, err := jsonFloat(v)
+		if err != nil {
+			return nil, err
+		}
+		if field.typ == protoFloat {
+			b = appendTag(b, field.number, wireFixed32)
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(f))), nil
+		}
+		b = appendTag(b, field.number, wireFixed64)
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(f)), nil
+	}
+	// 整数类型
+	unsigned := field.typ == protoUint32 || field.typ == protoUint64 || field.typ == protoFixed32 || field.typ == protoFixed64
+	var n uint64
+	if unsigned {
+		u, err := strconv.ParseUint(jsonNumber(v), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		n = u
+	} else {
+		i, err := strconv.ParseInt(jsonNumber(v), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		switch field.typ {
+		case protoSint32, protoSint64:
+			n = uint64(i<<1) ^ uint64(i>>63)
+		case protoInt32, protoEnum, protoSfixed32:
+			n = uint64(int64(int32(i)))
+		default:
+			n = uint64(i)
+		}
+	}
+	switch field.typ {
+	case protoFixed32, protoSfixed32:
+		b = appendTag(b, field.number, wireFixed32)
+		return binary.LittleEndian.AppendUint32(b, uint32(n)), nil
+	case protoFixed64, protoSfixed64:
+		b = appendTag(b, field.number, wireFixed64)
+		return binary.LittleEndian.AppendUint64(b, n), nil
+	}
+	return appendVarint(appendTag(b, field.number, wireVarint), n), nil
+}
+
+// jsonNumber 把JSON数值或字符串形式的整数转换为字符串
+func jsonNumber(v interface{}) string {
+	switch val := v.(type) {
+	case json.Number:
+		return val.String()
+	case string:
+		return val
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonFloat 把JSON数值转换为浮点数
+func jsonFloat(v interface{}) (float64, error) {
+	return strconv.ParseFloat(jsonNumber(v), 64)
+}
+
+// decode 把protobuf线路格式的消息解码为可编码为JSON的映射
+func (reg *protoRegistry) decode(msgName string, data []byte) (map[string]interface{}, error) {
+	msg, err := reg.message(msgName)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	r := &protoReader{buf: data}
+	for len(r.buf) > 0 {
+		num, wt, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		field, ok := msg.byNumber[num]
+		if !ok {
+			if err := r.skip(wt); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var values []interface{}
+		if wt == wireBytes && field.typ != protoString && field.typ != protoBytes && field.typ != protoMessage {
+			// 紧凑编码的重复标量字段
+			packed, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			pr := &protoReader{buf: packed}
+			for len(pr.buf) > 0 {
+				v, err := reg.decodeValue(pr, field, scalarWireType(field.typ))
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v)
+			}
+		} else {
+			v, err := reg.decodeValue(r, field, wt)
+			if err != nil {
+				return nil, err
+			}
+			values = []interface{}{v}
+		}
+		if entry := reg.mapEntry(field); entry != nil {
+			m, _ := obj[field.jsonName].(map[string]interface{})
+			if m == nil {
+				m = make(map[string]interface{})
+				obj[field.jsonName] = m
+			}
+			for _, v := range values {
+				k, val := reg.mapPair(entry, v.(map[string]interface{}))
+				m[k] = val
+			}
+		} else if field.label == protoLabelRepeated {
+			list, _ := obj[field.jsonName].([]interface{})
+			obj[field.jsonName] = append(list, values...)
+		} else {
+			obj[field.jsonName] = values[len(values)-1]
+		}
+	}
+	return obj, nil
+}
+
+// mapPair 从解码后的键值对消息中取出JSON对象的键和值,缺失的键或值取其类型的默认值
+func (reg *protoRegistry) mapPair(entry *protoMsgDesc, pair map[string]interface{}) (string, interface{}) {
+	keyField, valueField := entry.byNumber[1], entry.byNumber[2]
+	key, ok := pair[keyField.jsonName]
+	if !ok {
+		key = reg.defaultValue(keyField)
+	}
+	value, ok := pair[valueField.jsonName]
+	if !ok {
+		value = reg.defaultValue(valueField)
+	}
+	return fmt.Sprint(key), value
+}
+
+// defaultValue 返回字段类型的默认值,其JSON形式与decodeValue的结果一致
+func (reg *protoRegistry) defaultValue(field *protoField) interface{} {
+	switch field.typ {
+	case protoString, protoBytes:
+		return ""
+	case protoBool:
+		return false
+	case protoMessage:
+		return map[string]interface{}{}
+	case protoInt64, protoUint64, protoFixed64, protoSfixed64, protoSint64:
+		return "0"
+	case protoEnum:
+		return reg.enumValue(field, 0)
+	}
+	return 0
+}
+
+// enumValue 返回枚举值的名称,无法识别的值以数值表示
+func (reg *protoRegistry) enumValue(field *protoField, number int32) interface{} {
+	if enum, err := reg.enum(field.typeName); err == nil {
+		if name, ok := enum.byNumber[number]; ok {
+			return name
+		}
+	}
+	return number
+}
+
+// scalarWireType 返回标量类型的线路类型
+func scalarWireType(typ int) int {
+	switch typ {
+	case protoDouble, protoFixed64, protoSfixed64:
+		return wireFixed64
+	case protoFloat, protoFixed32, protoSfixed32:
+		return wireFixed32
+	}
+	return wireVarint
+}
+
+// decodeValue 解码一个字段值
+func (reg *protoRegistry) decodeValue(r *protoReader, field *protoField, wt int) (interface{}, error) {
+	switch wt {
+	case wireBytes:
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		switch field.typ {
+		case protoString:
+			return string(b), nil
+		case protoMessage:
+			return reg.decode(field.typeName, b)
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case wireFixed32:
+		v, err := r.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		switch field.typ {
+		case protoFloat:
+			return float64(math.Float32frombits(uint32(v))), nil
+		case protoSfixed32:
+			return int32(v), nil
+		}
+		return uint32(v), nil
+	case wireFixed64:
+		v, err := r.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		switch field.typ {
+		case protoDouble:
+			return math.Float64frombits(v), nil
+		case protoSfixed64:
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return strconv.FormatUint(v, 10), nil
+	case wireVarint:
+		v, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		switch field.typ {
+		case protoBool:
+			return v != 0, nil
+		case protoInt32:
+			return int32(v), nil
+		case protoEnum:
+			return reg.enumValue(field, int32(v)), nil
+		case protoUint32:
+			return uint32(v), nil
+		case protoSint32:
+			return int32(v>>1) ^ -int32(v&1), nil
+		case protoSint64:
+			return strconv.FormatInt(int64(v>>1)^-int64(v&1), 10), nil
+		case protoUint64:
+			return strconv.FormatUint(v, 10), nil
+		}
+		// int64按proto3 JSON映射编码为字符串
+		return strconv.FormatInt(int64(v), 10), nil
+	}
+	return nil, fmt.Errorf("protobuf: unsupported wire type %d", wt)
+}
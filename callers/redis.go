@@ -0,0 +1,481 @@
+package callers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 调用结果的Redis相关标签
+const (
+	TAG_REDIS_CMD      = "redis.cmd"      // 以逗号连接的命令名
+	TAG_REDIS_PIPELINE = "redis.pipeline" // 流水线深度
+)
+
+// 可接收的RESP回复的上限,防止依据服务端声明的长度分配过大的内存
+const (
+	redisMaxBulk      = 16 << 20 // 批量字符串的最大长度
+	redisMaxAggregate = 1 << 20  // 聚合类型的最大元素数
+)
+
+// RedisConfig 表示Redis调用器的配置
+type RedisConfig struct {
+	Addr     string   // 服务地址,形如host:port
+	Commands []string // 命令模板,参数以空白分隔,按请求序号轮流使用;可使用的函数见lib.TemplateFuncs,如"GET k:{{zipf 1.1 999}}"
+	// 含空白的参数可用双引号或单引号括起,与redis-cli相同:双引号内支持\n、\t、\"、\\和\xHH等转义,单引号内只支持\'
+
+	Protocol int    // RESP协议版本,取值为2或3,默认为2;为3时建立连接后发送HELLO 3
+	Pipeline int    // 流水线深度,即每次调用连续发送的命令数,默认为1
+	Conns    uint32 // 持久连接数,默认为16
+}
+
+// redisReply 表示一个RESP回复
+type redisReply struct {
+	Err   string      `json:",omitempty"` // 错误回复的内容
+	Value interface{} `json:",omitempty"` // 其他回复的值,空值为nil
+}
+
+// redisResp 表示序列化在原生响应中的Redis响应
+type redisResp struct {
+	Replies []redisReply
+}
+
+// RedisCaller 表示Redis调用器
+type RedisCaller struct {
+	cfg         RedisConfig
+	feederNames // 请求模板中使用的已注册数据源
+	tmpls       []*template.Template
+	pool        lib.ConnPool
+	seq         int64 // 请求序号
+}
+
+// NewRedisCaller 新建一个Redis调用器
+func NewRedisCaller(cfg RedisConfig) (lib.Caller, error) {
+	caller, err := newRedisCaller(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := lib.NewConnPool(caller.dial, lib.PoolConfig{Size: caller.cfg.Conns, HealthCheck: true})
+	if err != nil {
+		return nil, err
+	}
+	caller.pool = pool
+	return caller, nil
+}
+
+// newRedisCaller 校验配置并解析命令模板
+func newRedisCaller(cfg RedisConfig) (*RedisCaller, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("Invalid Redis address!")
+	}
+	if len(cfg.Commands) == 0 {
+		return nil, errors.New("Invalid Redis commands!")
+	}
+	switch cfg.Protocol {
+	case 0:
+		cfg.Protocol = 2
+	case 2, 3:
+	default:
+		return nil, fmt.Errorf("Invalid RESP protocol version: %d!", cfg.Protocol)
+	}
+	if cfg.Pipeline <= 0 {
+		cfg.Pipeline = 1
+	}
+	if cfg.Conns == 0 {
+		cfg.Conns = 16
+	}
+	caller := &RedisCaller{cfg: cfg}
+	for i, text := range cfg.Commands {
+		tmpl, err := template.New("command").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid command template #%d: %s", i, err)
+		}
+		caller.tmpls = append(caller.tmpls, tmpl)
+	}
+	caller.feederNames = templateFeeders(caller.tmpls...)
+	return caller, nil
+}
+
+// dial 建立一个连接,并在需要时协商RESP3协议
+func (caller *RedisCaller) dial(timeoutNS time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", caller.cfg.Addr, timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	if caller.cfg.Protocol == 3 {
+		conn.SetDeadline(time.Now().Add(timeoutNS))
+		if _, err := conn.Write(encodeRedisCommand([]string{"HELLO", "3"})); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		reply, err := readRedisReply(bufio.NewReader(conn))
+		if err == nil && reply.Err != "" {
+			err = fmt.Errorf("HELLO 3 rejected: %s", reply.Err)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, nil
+}
+
+// Close 关闭调用器持有的空闲连接
+func (caller *RedisCaller) Close() {
+	caller.pool.Close()
+}
+
+// BuildReq 依据模板构建一批流水线命令
+func (caller *RedisCaller) BuildReq() lib.RawReq {
+	id := time.Now().UnixNano()
+	cmds := make([][]string, caller.cfg.Pipeline)
+	for i := range cmds {
+		data := &TemplateData{
+			ID:   id,
+			Seq:  atomic.AddInt64(&caller.seq, 1),
+			Time: time.Now().UnixNano(),
+		}
+		tmpl := caller.tmpls[int((data.Seq-1)%int64(len(caller.tmpls)))]
+		r := &renderer{data: data}
+		line := r.render(tmpl)
+		if r.err != nil {
+			return lib.RawReq{ID: id, Err: r.err}
+		}
+		args, err := splitRedisArgs(line)
+		if err != nil {
+			return lib.RawReq{ID: id, Err: err}
+		}
+		cmds[i] = args
+	}
+	req, err := json.Marshal(cmds)
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: id, Req: req}
+}
+
+// Call 在一个连接上连续发送全部命令,再依次读取对应的回复
+func (caller *RedisCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var cmds [][]string
+	if err := json.Unmarshal(req, &cmds); err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for _, cmd := range cmds {
+		if len(cmd) == 0 {
+			return nil, errors.New("Empty Redis command!")
+		}
+		buf = append(buf, encodeRedisCommand(cmd)...)
+	}
+	deadline := time.Now().Add(timeoutNS)
+	conn, err := caller.pool.Get(timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	resp, err := caller.roundTrip(conn, buf, len(cmds))
+	caller.pool.Put(conn, err != nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resp)
+}
+
+// roundTrip 发送编码后的命令并读取n个回复
+func (caller *RedisCaller) roundTrip(conn net.Conn, buf []byte, n int) (*redisResp, error) {
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp := &redisResp{Replies: make([]redisReply, 0, n)}
+	for len(resp.Replies) < n {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return nil, err
+		}
+		resp.Replies = append(resp.Replies, *reply)
+	}
+	if r.Buffered() > 0 {
+		return nil, errors.New("Unexpected trailing data in Redis reply!")
+	}
+	return resp, nil
+}
+
+// CheckResp 检查回复,任一命令返回错误回复时以RET_CODE_ERROR_CALEE表示
+func (caller *RedisCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var cmds [][]string
+	if err := json.Unmarshal(rawReq.Req, &cmds); err != nil || len(cmds) == 0 || len(cmds[0]) == 0 {
+		result.Code = lib.RET_CODE_FATAL_CALL
+		result.Msg = fmt.Sprintf("Incorrectly formatted Req: %s!", string(rawReq.Req))
+		return &result
+	}
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		if len(cmd) > 0 {
+			names[i] = strings.ToUpper(cmd[0])
+		}
+	}
+	result.SetTag(TAG_REDIS_CMD, strings.Join(names, ","))
+	result.SetTag(TAG_REDIS_PIPELINE, strconv.Itoa(len(cmds)))
+
+	var resp redisResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	if len(resp.Replies) != len(cmds) {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrect number of replies! (%d!=%d)", len(resp.Replies), len(cmds))
+		return &result
+	}
+	for i, reply := range resp.Replies {
+		if reply.Err != "" {
+			result.Code = lib.RET_CODE_ERROR_CALEE
+			result.Msg = fmt.Sprintf("Redis error: %s! (command=%s)", reply.Err, strings.Join(cmds[i], " "))
+			return &result
+		}
+	}
+	result.Code = lib.RET_CODE_SUCCESS
+	result.Msg = fmt.Sprintf("Success. (%d replies)", len(resp.Replies))
+	return &result
+}
+
+// splitRedisArgs 以redis-cli的规则把一行命令拆分为参数
+func splitRedisArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isRedisSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		switch quote := line[i]; quote {
+		case '"', '\'':
+			i++
+			for {
+				if i == len(line) {
+					return nil, fmt.Errorf("Unbalanced quotes in Redis command: %s", line)
+				}
+				c := line[i]
+				if c == quote {
+					i++
+					break
+				}
+				if c == '\\' && i+1 < len(line) {
+					next := line[i+1]
+					if quote == '\'' {
+						if next == '\'' {
+							c, i = next, i+1
+						}
+					} else {
+						var n int
+						c, n = redisEscape(line[i+1:])
+						i += n
+					}
+				}
+				arg = append(arg, c)
+				i++
+			}
+			if i < len(line) && !isRedisSpace(line[i]) {
+				return nil, fmt.Errorf("Closing quote must be followed by a space in Redis command: %s", line)
+			}
+		default:
+			for i < len(line) && !isRedisSpace(line[i]) {
+				arg = append(arg, line[i])
+				i++
+			}
+		}
+		args = append(args, string(arg))
+	}
+}
+
+// redisEscape 解析双引号内反斜杠之后的转义序列,返回对应的字节和消耗的长度
+// 无法识别的转义序列代表反斜杠之后的字符本身
+func redisEscape(s string) (byte, int) {
+	switch s[0] {
+	case 'n':
+		return '\n', 1
+	case 'r':
+		return '\r', 1
+	case 't':
+		return '\t', 1
+	case 'b':
+		return '\b', 1
+	case 'a':
+		return '\a', 1
+	case 'x':
+		if len(s) >= 3 {
+			if b, err := strconv.ParseUint(s[1:3], 16, 8); err == nil {
+				return byte(b), 3
+			}
+		}
+	}
+	return s[0], 1
+}
+
+// isRedisSpace 判断c是否为分隔参数的空白
+func isRedisSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// encodeRedisCommand 把命令编码为由批量字符串组成的RESP数组
+func encodeRedisCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// readRedisReply 读取一个RESP2或RESP3回复
+// 属性(|)会被跳过,映射(%)以键值交替的切片表示,集合(~)和推送(>)以切片表示
+func readRedisReply(r *bufio.Reader) (*redisReply, error) {
+	for {
+		kind, line, err := readRedisLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case '-':
+			return &redisReply{Err: line}, nil
+		case '!':
+			data, err := readRedisBulk(r, line)
+			if err != nil {
+				return nil, err
+			}
+			return &redisReply{Err: string(data)}, nil
+		case '|':
+			n, err := strconv.Atoi(line)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("Invalid RESP attribute length: %q", line)
+			}
+			if n > redisMaxAggregate {
+				return nil, fmt.Errorf("RESP attribute too large! (len=%d, max=%d)", n, redisMaxAggregate)
+			}
+			if _, err := readRedisValues(r, 2*n); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value, err := readRedisValue(r, kind, line)
+		if err != nil {
+			return nil, err
+		}
+		return &redisReply{Value: value}, nil
+	}
+}
+
+// readRedisValue 依据类型前缀读取一个非错误的RESP值
+func readRedisValue(r *bufio.Reader, kind byte, line string) (interface{}, error) {
+	switch kind {
+	case '+', '(':
+		return line, nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case ',':
+		return strconv.ParseFloat(line, 64)
+	case '#':
+		return line == "t", nil
+	case '_':
+		return nil, nil
+	case '$', '=':
+		if line == "-1" {
+			return nil, nil
+		}
+		data, err := readRedisBulk(r, line)
+		if err != nil {
+			return nil, err
+		}
+		if kind == '=' && len(data) >= 4 {
+			// 去掉形如"txt:"的格式前缀
+			data = data[4:]
+		}
+		return string(data), nil
+	case '*', '~', '>', '%':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid RESP aggregate length: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > redisMaxAggregate {
+			return nil, fmt.Errorf("RESP aggregate too large! (len=%d, max=%d)", n, redisMaxAggregate)
+		}
+		if kind == '%' {
+			n *= 2
+		}
+		return readRedisValues(r, n)
+	}
+	return nil, fmt.Errorf("Unknown RESP type: %q", kind)
+}
+
+// readRedisValues 读取n个连续的RESP值,其中的错误回复以字符串表示
+func readRedisValues(r *bufio.Reader, n int) ([]interface{}, error) {
+	// 按实际读到的值增长,不依据服务端声明的长度预分配
+	values := make([]interface{}, 0, min(n, 64))
+	for i := 0; i < n; i++ {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Err != "" {
+			values = append(values, reply.Err)
+		} else {
+			values = append(values, reply.Value)
+		}
+	}
+	return values, nil
+}
+
+// readRedisLine 读取一行,返回类型前缀和去掉CRLF后的内容
+func readRedisLine(r *bufio.Reader) (byte, string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return 0, "", fmt.Errorf("Invalid RESP line: %q", line)
+	}
+	return line[0], line[1 : len(line)-2], nil
+}
+
+// readRedisBulk 读取长度为size的批量数据及其后的CRLF
+func readRedisBulk(r *bufio.Reader, size string) ([]byte, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("Invalid RESP bulk length: %q", size)
+	}
+	if n > redisMaxBulk {
+		return nil, fmt.Errorf("RESP bulk too large! (len=%d, max=%d)", n, redisMaxBulk)
+	}
+	data := make([]byte, n+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
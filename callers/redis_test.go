@@ -0,0 +1,133 @@
+package callers
+
+import (
+	"bufio"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+func TestRedisCaller(t *testing.T) {
+	server := helper.NewRedisServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	for _, protocol := range []int{2, 3} {
+		caller, err := NewRedisCaller(RedisConfig{
+			Addr: server.Addr().String(),
+			Commands: []string{
+				`SET k:{{seqRange "redis.test" 0 4}} {{.Seq}}`,
+				`GET miss:{{randInt 0 4}}`,
+				`INCR counter`,
+			},
+			Protocol: protocol,
+			Pipeline: 3,
+			Conns:    2,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			result := call(caller, time.Second)
+			if result.Code != lib.RET_CODE_SUCCESS {
+				t.Fatalf("RESP%d: unexpected result: %v", protocol, result)
+			}
+			if result.Tag(TAG_REDIS_PIPELINE) != "3" || result.Tag(TAG_REDIS_CMD) != "SET,GET,INCR" {
+				t.Fatalf("RESP%d: unexpected tags: %v", protocol, result.Tags)
+			}
+			var resp redisResp
+			if err := json.Unmarshal(result.Resp.Resp, &resp); err != nil {
+				t.Fatal(err)
+			}
+			// GET读取的键从未写入
+			if resp.Replies[1].Value != nil {
+				t.Fatalf("RESP%d: unexpected GET reply: %v", protocol, resp.Replies[1])
+			}
+		}
+		caller.(*RedisCaller).Close()
+	}
+
+	caller, err := NewRedisCaller(RedisConfig{
+		Addr:     server.Addr().String(),
+		Commands: []string{`SET text abc`, `INCR text`},
+		Pipeline: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.(*RedisCaller).Close()
+	result := call(caller, time.Second)
+	if result.Code != lib.RET_CODE_ERROR_CALEE {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	// 引号括起的参数可以包含空白
+	caller, err = NewRedisCaller(RedisConfig{
+		Addr:     server.Addr().String(),
+		Commands: []string{`SET "greeting k" "hello\tworld \"{{.Seq}}\""`, `GET 'greeting k'`},
+		Pipeline: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.(*RedisCaller).Close()
+	result = call(caller, time.Second)
+	var resp redisResp
+	if err := json.Unmarshal(result.Resp.Resp, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != lib.RET_CODE_SUCCESS || resp.Replies[1].Value != "hello\tworld \"1\"" {
+		t.Fatalf("Unexpected result: %v (replies=%v)", result, resp.Replies)
+	}
+	caller, _ = NewRedisCaller(RedisConfig{Addr: server.Addr().String(), Commands: []string{`GET "k`}})
+	if rawReq := caller.BuildReq(); rawReq.Err == nil {
+		t.Fatal("Expected an error with unbalanced quotes!")
+	}
+
+	if _, err := NewRedisCaller(RedisConfig{Addr: "127.0.0.1:6379", Commands: []string{"PING"}, Protocol: 4}); err == nil {
+		t.Fatal("Expected an error with invalid protocol version!")
+	}
+}
+
+func TestSplitRedisArgs(t *testing.T) {
+	for line, want := range map[string][]string{
+		`SET k v`:              {"SET", "k", "v"},
+		`  SET  "a b"  'c d' `: {"SET", "a b", "c d"},
+		`SET k "\x41\n\\"`:     {"SET", "k", "A\n\\"},
+		`SET k 'it\'s' ""`:     {"SET", "k", "it's", ""},
+		`SET k "\xZZ"`:         {"SET", "k", "xZZ"},
+		`SET k "\\"`:           {"SET", "k", "\\"},
+	} {
+		args, err := splitRedisArgs(line)
+		if err != nil || !reflect.DeepEqual(args, want) {
+			t.Fatalf("%s: unexpected args: %q (err=%v)", line, args, err)
+		}
+	}
+	for _, line := range []string{`GET "k`, `GET 'k`, `GET "k"v`} {
+		if _, err := splitRedisArgs(line); err == nil {
+			t.Fatalf("%s: expected an error!", line)
+		}
+	}
+}
+
+func TestReadRedisReplyLimits(t *testing.T) {
+	for _, data := range []string{
+		"$" + strconv.Itoa(redisMaxBulk+1) + "\r\n",
+		"*" + strconv.Itoa(redisMaxAggregate+1) + "\r\n",
+		"|" + strconv.Itoa(redisMaxAggregate+1) + "\r\n",
+		"|-1\r\n",
+	} {
+		_, err := readRedisReply(bufio.NewReader(strings.NewReader(data)))
+		if err == nil {
+			t.Fatalf("%q: expected an error!", data)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package callers
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 注册本包中可由URL和选项直接构建的调用器
+// gRPC、命令、数据库和流式调用器需要描述符集合、函数等无法用选项表达的配置,不在此注册
+func init() {
+	lib.RegisterCaller("http", newHTTPFromURL)
+	lib.RegisterCaller("https", newHTTPFromURL)
+	lib.RegisterCaller("h2c", newHTTP2FromURL)
+	lib.RegisterCaller("h2", newHTTP2FromURL)
+	lib.RegisterCaller("ws", newWebSocketFromURL)
+	lib.RegisterCaller("wss", newWebSocketFromURL)
+	lib.RegisterCaller("udp", newUDPFromURL)
+	lib.RegisterCaller("redis", newRedisFromURL)
+	lib.RegisterCaller("mqtt", newMQTTFromURL)
+	lib.RegisterCaller("nats", newNATSFromURL)
+}
+
+// optionReader 表示读取选项时记录第一个错误和已读取的键的辅助类型
+type optionReader struct {
+	opts lib.Options
+	used map[string]bool // 已读取的键
+	err  error
+}
+
+// use 记录已读取的键
+func (r *optionReader) use(key string) {
+	if r.used == nil {
+		r.used = make(map[string]bool)
+	}
+	r.used[key] = true
+}
+
+// setErr 记录第一个错误
+func (r *optionReader) setErr(err error) {
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// done 返回读取选项时的第一个错误,没有错误但存在未读取的键(如拼写错误)时也返回错误
+func (r *optionReader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	var unknown []string
+	for k := range r.opts {
+		if !r.used[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Unknown options: %s!", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// str 获取字符串选项
+func (r *optionReader) str(key, def string) string {
+	r.use(key)
+	return r.opts.String(key, def)
+}
+
+// integer 获取整数选项
+func (r *optionReader) integer(key string, def int) int {
+	r.use(key)
+	v, err := r.opts.Int(key, def)
+	r.setErr(err)
+	return v
+}
+
+// boolean 获取布尔选项
+func (r *optionReader) boolean(key string, def bool) bool {
+	r.use(key)
+	v, err := r.opts.Bool(key, def)
+	r.setErr(err)
+	return v
+}
+
+// duration 获取时长选项
+func (r *optionReader) duration(key string, def time.Duration) time.Duration {
+	r.use(key)
+	v, err := r.opts.Duration(key, def)
+	r.setErr(err)
+	return v
+}
+
+// count 获取非负的计数选项,负值记为错误而不是转换为很大的无符号数
+func (r *optionReader) count(key string, def int) uint32 {
+	v := r.integer(key, def)
+	if v < 0 || int64(v) > math.MaxUint32 {
+		r.setErr(fmt.Errorf("Invalid option %s: %d!", key, v))
+		return 0
+	}
+	return uint32(v)
+}
+
+// qos 获取MQTT服务质量等级选项,超出byte范围的值记为错误而不是截断
+func (r *optionReader) qos(key string) byte {
+	v := r.integer(key, 0)
+	if v < 0 || v > 2 {
+		r.setErr(fmt.Errorf("Invalid MQTT QoS: %d!", v))
+		return 0
+	}
+	return byte(v)
+}
+
+// prefixed 返回以prefix开头的选项,键中去掉该前缀,如"header.X-Token"
+func (r *optionReader) prefixed(prefix string) map[string]string {
+	m := make(map[string]string)
+	for k, v := range r.opts {
+		if strings.HasPrefix(k, prefix) {
+			r.use(k)
+			m[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return m
+}
+
+// templateURL 还原URL,路径不做转义,以保留其中的模板动作
+func templateURL(u *url.URL) string {
+	s := u.Scheme + "://"
+	if u.User != nil {
+		s += u.User.String() + "@"
+	}
+	s += u.Host + u.Path
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	return s
+}
+
+// httpConfigFromURL 依据选项method、body、header.*、body_contains、disable_keep_alive和max_body_size生成HTTP配置
+func httpConfigFromURL(u *url.URL, r *optionReader) HTTPConfig {
+	return HTTPConfig{
+		Method:           r.str("method", "GET"),
+		URL:              templateURL(u),
+		Header:           r.prefixed("header."),
+		Body:             r.str("body", ""),
+		BodyContains:     r.str("body_contains", ""),
+		DisableKeepAlive: r.boolean("disable_keep_alive", false),
+		MaxBodySize:      int64(r.integer("max_body_size", 0)),
+	}
+}
+
+// newHTTPFromURL 构建HTTP/1.1调用器
+func newHTTPFromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	cfg := httpConfigFromURL(u, r)
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewHTTPCaller(cfg)
+}
+
+// newHTTP2FromURL 构建HTTP/2调用器,h2c://表示明文,h2://表示基于TLS,另支持选项conns和streams
+func newHTTP2FromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	target := *u
+	cleartext := u.Scheme == "h2c"
+	if cleartext {
+		target.Scheme = "http"
+	} else {
+		target.Scheme = "https"
+	}
+	cfg := HTTP2Config{
+		HTTPConfig:     httpConfigFromURL(&target, r),
+		Cleartext:      cleartext,
+		Conns:          r.integer("conns", 1),
+		StreamsPerConn: r.count("streams", 100),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewHTTP2Caller(cfg)
+}
+
+// newWebSocketFromURL 构建WebSocket调用器,支持选项message、correlation_field、conns和header.*
+func newWebSocketFromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	cfg := WebSocketConfig{
+		URL:              u.String(),
+		Header:           r.prefixed("header."),
+		Message:          r.str("message", ""),
+		CorrelationField: r.str("correlation_field", ""),
+		Conns:            r.integer("conns", 0),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewWebSocketCaller(cfg)
+}
+
+// newUDPFromURL 构建UDP调用器,支持选项message、wait_reply、correlation_field、reply_timeout和max_datagram
+func newUDPFromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	cfg := UDPConfig{
+		Addr:             u.Host,
+		Message:          r.str("message", ""),
+		WaitReply:        r.boolean("wait_reply", false),
+		CorrelationField: r.str("correlation_field", ""),
+		ReplyTimeout:     r.duration("reply_timeout", 0),
+		MaxDatagram:      r.integer("max_datagram", 0),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewUDPCaller(cfg)
+}
+
+// newRedisFromURL 构建Redis调用器,选项commands中的多条命令以分号分隔,另支持protocol、pipeline和conns
+func newRedisFromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	var commands []string
+	for _, cmd := range strings.Split(r.str("commands", "PING"), ";") {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			commands = append(commands, cmd)
+		}
+	}
+	cfg := RedisConfig{
+		Addr:     u.Host,
+		Commands: commands,
+		Protocol: r.integer("protocol", 2),
+		Pipeline: r.integer("pipeline", 1),
+		Conns:    r.count("conns", 16),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewRedisCaller(cfg)
+}
+
+// newMQTTFromURL 构建MQTT调用器,主题取自URL路径或选项topic,用户信息取自URL
+// 另支持选项payload、qos、client_id、end_to_end、subscribe_topic和max_packet_size
+func newMQTTFromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	cfg := MQTTConfig{
+		Addr:           u.Host,
+		ClientID:       r.str("client_id", ""),
+		Topic:          r.str("topic", strings.TrimPrefix(u.Path, "/")),
+		Payload:        r.str("payload", ""),
+		QoS:            r.qos("qos"),
+		EndToEnd:       r.boolean("end_to_end", false),
+		SubscribeTopic: r.str("subscribe_topic", ""),
+		MaxPacketSize:  r.integer("max_packet_size", 0),
+	}
+	if u.User != nil {
+		cfg.Username = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewMQTTCaller(cfg)
+}
+
+// newNATSFromURL 构建NATS调用器,主题取自URL路径或选项subject,令牌取自URL的用户信息
+// 另支持选项payload、mode、end_to_end和subscribe_subject
+func newNATSFromURL(u *url.URL, opts lib.Options) (lib.Caller, error) {
+	r := &optionReader{opts: opts}
+	cfg := NATSConfig{
+		Addr:             u.Host,
+		Subject:          r.str("subject", strings.TrimPrefix(u.Path, "/")),
+		Payload:          r.str("payload", ""),
+		Mode:             r.str("mode", ""),
+		EndToEnd:         r.boolean("end_to_end", false),
+		SubscribeSubject: r.str("subscribe_subject", ""),
+	}
+	if u.User != nil {
+		cfg.Token = u.User.Username()
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return NewNATSCaller(cfg)
+}
@@ -0,0 +1,69 @@
+package callers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+func TestCallerRegistry(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(r.Header.Get("X-Token")))
+	}))
+	defer server.Close()
+
+	caller, err := lib.NewCaller(server.URL+"/items/{{.Seq}}", lib.Options{
+		"header.X-Token": "secret",
+		"body_contains":  "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	if path != "/items/1" {
+		t.Fatalf("Unexpected path: %s", path)
+	}
+
+	redis := helper.NewRedisServer()
+	if err := redis.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close()
+	caller, err = lib.NewCaller("redis://"+redis.Addr().String(), lib.Options{
+		"commands": "SET k {{.Seq}}; GET k",
+		"pipeline": "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := call(caller, time.Second); result.Code != lib.RET_CODE_SUCCESS || result.Tag(TAG_REDIS_PIPELINE) != "2" {
+		t.Fatalf("Unexpected result: %v (tags=%v)", result, result.Tags)
+	}
+
+	if _, err := lib.NewCaller("udp://127.0.0.1:9", lib.Options{"wait_reply": "maybe"}); err == nil {
+		t.Fatal("Expected an error with invalid option!")
+	}
+	// 拼写错误的选项不被静默忽略
+	_, err = lib.NewCaller("redis://"+redis.Addr().String(), lib.Options{"pipline": "8"})
+	if err == nil || !strings.Contains(err.Error(), "pipline") {
+		t.Fatalf("Expected an unknown option error, got: %v", err)
+	}
+	// 超出范围的整数选项报告错误而不是截断
+	for _, opts := range []lib.Options{{"qos": "258"}, {"qos": "-1"}} {
+		if _, err := lib.NewCaller("mqtt://127.0.0.1:1/t", opts); err == nil || !strings.Contains(err.Error(), "QoS") {
+			t.Fatalf("%v: expected a QoS error, got: %v", opts, err)
+		}
+	}
+	if _, err := lib.NewCaller("redis://127.0.0.1:1", lib.Options{"conns": "-1"}); err == nil || !strings.Contains(err.Error(), "conns") {
+		t.Fatalf("Expected a conns error, got: %v", err)
+	}
+}
@@ -0,0 +1,449 @@
+package callers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkjson"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+
+	"loadgen/lib"
+)
+
+// scriptMaxSteps 表示单次执行脚本函数可执行的最大步数,用于终止失控的脚本
+const scriptMaxSteps = 10000000
+
+// ScriptConfig 表示脚本调用器的配置
+//
+// 脚本使用Starlark(Python方言)编写,须定义两个函数:
+//
+//	def build_req(ctx): ...       生成请求内容,返回字符串或bytes;ctx包含id、seq和time字段
+//	def check_resp(req, resp): ...  检查响应,req和resp为字符串
+//
+// check_resp返回None表示成功,返回字符串表示成功及其说明,
+// 返回结果代码(如ERROR_CALEE)或(结果代码, 说明)元组表示相应的结果。
+//
+// 脚本中可使用的内置值:
+//
+//	json                       Starlark的JSON模块,含encode、decode和indent
+//	parse_json(s)              解析JSON,无法解析时返回None
+//	feed(name)                 读取数据源中的一条记录,返回dict
+//	state_get(key, default)    读取跨调用共享的状态
+//	state_set(key, value)      设置跨调用共享的状态,value会被冻结
+//	state_incr(key, delta=1)   原子地增加整数状态并返回新值
+//	OK、ERROR_RESPONSE、ERROR_CALEE、ERROR_CALL  结果代码
+//
+// 此外lib.TemplateFuncs中的值生成函数以同名内置函数提供(now除外),如randInt(1, 10)、uuid()、zipf(1.1, 999)。
+// 脚本的顶层变量在加载后被冻结,build_req和check_resp可被多个goroutine并发调用,可变状态须使用state_*函数。
+type ScriptConfig struct {
+	Script     string                         // 脚本内容,为空时从ScriptFile加载
+	ScriptFile string                         // 脚本文件路径
+	Transport  lib.Caller                     // 负责实际发送请求的调用器,仅使用其Call方法
+	Feeds      map[string][]map[string]string // 数据源,在脚本中以feed函数按顺序循环读取,未列出的名称从lib.RegisterFeeder注册的数据源读取
+}
+
+// scriptCodes 表示脚本中可使用的结果代码
+var scriptCodes = starlark.StringDict{
+	"OK":             starlark.MakeInt(int(lib.RET_CODE_SUCCESS)),
+	"ERROR_RESPONSE": starlark.MakeInt(lib.RET_CODE_ERROR_RESPONSE),
+	"ERROR_CALEE":    starlark.MakeInt(lib.RET_CODE_ERROR_CALEE),
+	"ERROR_CALL":     starlark.MakeInt(lib.RET_CODE_ERROR_CALL),
+}
+
+// ScriptCaller 表示由Starlark脚本构建请求和检查响应的调用器
+type ScriptCaller struct {
+	cfg         ScriptConfig
+	feederNames // 脚本中使用的已注册数据源
+	buildReq    starlark.Callable
+	checkResp   starlark.Callable
+	seq         int64 // 请求序号
+	feedMu      sync.Mutex
+	feeds       map[string]int // 各数据源下一次读取的位置
+	stateMu     sync.Mutex
+	state       map[string]starlark.Value // 跨调用共享的状态
+}
+
+// NewScriptCaller 新建一个脚本调用器,加载脚本时执行其顶层语句
+func NewScriptCaller(cfg ScriptConfig) (lib.Caller, error) {
+	if cfg.Transport == nil {
+		return nil, errors.New("Invalid transport caller!")
+	}
+	script, filename := cfg.Script, "script.star"
+	if script == "" {
+		if cfg.ScriptFile == "" {
+			return nil, errors.New("Invalid script!")
+		}
+		data, err := ioutil.ReadFile(cfg.ScriptFile)
+		if err != nil {
+			return nil, err
+		}
+		script, filename = string(data), cfg.ScriptFile
+	}
+	caller := &ScriptCaller{
+		cfg:   cfg,
+		feeds: make(map[string]int),
+		state: make(map[string]starlark.Value),
+	}
+	opts := &syntax.FileOptions{Set: true}
+	file, err := opts.Parse(filename, script, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid script: %s", err)
+	}
+	caller.feederNames = scriptFeeders(file, cfg.Feeds)
+	globals, err := starlark.ExecFileOptions(opts, caller.thread(), filename, script, caller.predeclared())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid script: %s", scriptError(err))
+	}
+	for name, fn := range map[string]*starlark.Callable{"build_req": &caller.buildReq, "check_resp": &caller.checkResp} {
+		v, ok := globals[name].(starlark.Callable)
+		if !ok {
+			return nil, fmt.Errorf("Missing function %s in script!", name)
+		}
+		*fn = v
+	}
+	return caller, nil
+}
+
+// scriptFeeders 返回脚本中以字符串常量调用feed函数、且不在配置的数据源中的名称
+func scriptFeeders(file *syntax.File, feeds map[string][]map[string]string) feederNames {
+	set := make(map[string]bool)
+	syntax.Walk(file, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || len(call.Args) != 1 {
+			return true
+		}
+		fn, ok1 := call.Fn.(*syntax.Ident)
+		lit, ok2 := call.Args[0].(*syntax.Literal)
+		if ok1 && ok2 && fn.Name == "feed" && lit.Token == syntax.STRING {
+			if name := lit.Value.(string); feeds[name] == nil {
+				set[name] = true
+			}
+		}
+		return true
+	})
+	var names feederNames
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// thread 新建一个执行脚本的线程
+func (caller *ScriptCaller) thread() *starlark.Thread {
+	thread := &starlark.Thread{Name: "script"}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	return thread
+}
+
+// predeclared 返回脚本中可使用的内置值
+func (caller *ScriptCaller) predeclared() starlark.StringDict {
+	predeclared := starlark.StringDict{
+		"json":       starlarkjson.Module,
+		"parse_json": starlark.NewBuiltin("parse_json", scriptParseJSON),
+		"feed":       starlark.NewBuiltin("feed", caller.feed),
+		"state_get":  starlark.NewBuiltin("state_get", caller.stateGet),
+		"state_set":  starlark.NewBuiltin("state_set", caller.stateSet),
+		"state_incr": starlark.NewBuiltin("state_incr", caller.stateIncr),
+	}
+	for name, fn := range lib.TemplateFuncs() {
+		if _, ok := predeclared[name]; !ok && name != "now" {
+			predeclared[name] = scriptGenerator(name, fn)
+		}
+	}
+	for name, code := range scriptCodes {
+		predeclared[name] = code
+	}
+	return predeclared
+}
+
+// scriptError 返回脚本错误的说明,执行错误附带调用栈
+func scriptError(err error) string {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return evalErr.Backtrace()
+	}
+	return err.Error()
+}
+
+// scriptParseJSON 解析JSON,无法解析时返回None
+func scriptParseJSON(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var s string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+		return nil, err
+	}
+	decode := starlarkjson.Module.Members["decode"]
+	v, err := starlark.Call(thread, decode, starlark.Tuple{starlark.String(s)}, nil)
+	if err != nil {
+		return starlark.None, nil
+	}
+	return v, nil
+}
+
+// scriptGenerator 把lib.TemplateFuncs中的值生成函数包装为Starlark内置函数
+func scriptGenerator(name string, fn interface{}) *starlark.Builtin {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 {
+			return nil, fmt.Errorf("%s: unexpected keyword arguments", name)
+		}
+		fixed := ft.NumIn()
+		if ft.IsVariadic() {
+			fixed--
+		}
+		if len(args) < fixed || (!ft.IsVariadic() && len(args) > fixed) {
+			return nil, fmt.Errorf("%s: got %d arguments, want %d", name, len(args), fixed)
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var t reflect.Type
+			if i < fixed {
+				t = ft.In(i)
+			} else {
+				t = ft.In(fixed).Elem()
+			}
+			v, err := fromStarlark(arg, t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: argument #%d: %s", name, i+1, err)
+			}
+			in[i] = v
+		}
+		out := fv.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		return toStarlark(out[0].Interface())
+	})
+}
+
+// fromStarlark 把Starlark值转换为指定类型的Go值
+func fromStarlark(v starlark.Value, t reflect.Type) (reflect.Value, error) {
+	switch t.Kind() {
+	case reflect.String:
+		if s, ok := starlark.AsString(v); ok {
+			return reflect.ValueOf(s).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int64:
+		if i, ok := v.(starlark.Int); ok {
+			if n, ok := i.Int64(); ok {
+				return reflect.ValueOf(n).Convert(t), nil
+			}
+		}
+	case reflect.Float64:
+		if f, ok := starlark.AsFloat(v); ok {
+			return reflect.ValueOf(f), nil
+		}
+	case reflect.Interface:
+		return reflect.ValueOf(v), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", v.Type(), t)
+}
+
+// toStarlark 把Go值转换为Starlark值
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch val := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case starlark.Value:
+		return val, nil
+	case string:
+		return starlark.String(val), nil
+	case bool:
+		return starlark.Bool(val), nil
+	case int:
+		return starlark.MakeInt(val), nil
+	case int64:
+		return starlark.MakeInt64(val), nil
+	case uint64:
+		return starlark.MakeUint64(val), nil
+	case float64:
+		return starlark.Float(val), nil
+	case lib.Record:
+		return recordDict(val), nil
+	case map[string]string:
+		return recordDict(val), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", v)
+}
+
+// recordDict 把记录转换为按键排序的Starlark字典
+func recordDict(record map[string]string) *starlark.Dict {
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	dict := starlark.NewDict(len(record))
+	for _, k := range keys {
+		dict.SetKey(starlark.String(k), starlark.String(record[k]))
+	}
+	return dict
+}
+
+// feed 按顺序循环读取数据源中的一行,配置中没有该数据源时从已注册的数据源读取
+func (caller *ScriptCaller) feed(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &name); err != nil {
+		return nil, err
+	}
+	rows, ok := caller.cfg.Feeds[name]
+	if !ok {
+		if feeder, ok := lib.LookupFeeder(name); ok {
+			record, err := feeder.Next()
+			if err != nil {
+				return nil, err
+			}
+			return recordDict(record), nil
+		}
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("Unknown or empty feed: %s!", name)
+	}
+	caller.feedMu.Lock()
+	i := caller.feeds[name]
+	caller.feeds[name] = (i + 1) % len(rows)
+	caller.feedMu.Unlock()
+	return recordDict(rows[i]), nil
+}
+
+// stateGet 读取共享状态,不存在时返回默认值
+func (caller *ScriptCaller) stateGet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var def starlark.Value = starlark.None
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &def); err != nil {
+		return nil, err
+	}
+	caller.stateMu.Lock()
+	defer caller.stateMu.Unlock()
+	if v, ok := caller.state[key]; ok {
+		return v, nil
+	}
+	return def, nil
+}
+
+// stateSet 设置共享状态,值被冻结后才可在并发的调用间共享
+func (caller *ScriptCaller) stateSet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &key, &v); err != nil {
+		return nil, err
+	}
+	v.Freeze()
+	caller.stateMu.Lock()
+	caller.state[key] = v
+	caller.stateMu.Unlock()
+	return starlark.None, nil
+}
+
+// stateIncr 原子地增加整数状态并返回新值
+func (caller *ScriptCaller) stateIncr(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	delta := starlark.MakeInt(1)
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &key, &delta); err != nil {
+		return nil, err
+	}
+	caller.stateMu.Lock()
+	defer caller.stateMu.Unlock()
+	n := starlark.MakeInt(0)
+	if v, ok := caller.state[key]; ok {
+		if n, ok = v.(starlark.Int); !ok {
+			return nil, fmt.Errorf("%s: state %q is not an int (got %s)", b.Name(), key, v.Type())
+		}
+	}
+	n = n.Add(delta)
+	caller.state[key] = n
+	return n, nil
+}
+
+// BuildReq 执行脚本的build_req函数构建一个请求
+func (caller *ScriptCaller) BuildReq() lib.RawReq {
+	id := time.Now().UnixNano()
+	ctx := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id":   starlark.MakeInt64(id),
+		"seq":  starlark.MakeInt64(atomic.AddInt64(&caller.seq, 1)),
+		"time": starlark.MakeInt64(time.Now().UnixNano()),
+	})
+	v, err := starlark.Call(caller.thread(), caller.buildReq, starlark.Tuple{ctx}, nil)
+	if err != nil {
+		return lib.RawReq{ID: id, Err: fmt.Errorf("Script error: %w", err)}
+	}
+	switch req := v.(type) {
+	case starlark.String:
+		return lib.RawReq{ID: id, Req: []byte(req)}
+	case starlark.Bytes:
+		return lib.RawReq{ID: id, Req: []byte(req)}
+	}
+	return lib.RawReq{ID: id, Err: fmt.Errorf("build_req must return a string or bytes, got %s!", v.Type())}
+}
+
+// EditRequest 由传输调用器改写请求,传输调用器须实现lib.RequestEditor
+func (caller *ScriptCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	transport := lib.UnwrapCaller(caller.cfg.Transport)
+	editor, ok := transport.(lib.RequestEditor)
+	if !ok {
+		return nil, fmt.Errorf("Transport %T can not edit requests!", transport)
+	}
+	return editor.EditRequest(req, edit)
+}
+
+// Call 使用传输调用器发送请求
+func (caller *ScriptCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	return caller.cfg.Transport.Call(req, timeoutNS)
+}
+
+// CheckResp 执行脚本的check_resp函数检查响应,脚本出错时以RET_CODE_FATAL_CALL表示
+func (caller *ScriptCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	args := starlark.Tuple{starlark.String(rawReq.Req), starlark.String(rawResp.Resp)}
+	v, err := starlark.Call(caller.thread(), caller.checkResp, args, nil)
+	if err != nil {
+		result.Code = lib.RET_CODE_FATAL_CALL
+		result.Msg = fmt.Sprintf("Script error: %s", scriptError(err))
+		return &result
+	}
+	code, msg, err := scriptResult(v)
+	if err != nil {
+		result.Code = lib.RET_CODE_FATAL_CALL
+		result.Msg = err.Error()
+		return &result
+	}
+	result.Code = code
+	result.Msg = msg
+	return &result
+}
+
+// scriptResult 把check_resp的返回值转换为结果代码和说明
+func scriptResult(v starlark.Value) (lib.RetCode, string, error) {
+	switch val := v.(type) {
+	case starlark.NoneType:
+		return lib.RET_CODE_SUCCESS, "Success.", nil
+	case starlark.String:
+		return lib.RET_CODE_SUCCESS, string(val), nil
+	case starlark.Int:
+		if n, ok := val.Int64(); ok {
+			return lib.RetCode(n), "", nil
+		}
+	case starlark.Tuple:
+		if len(val) == 2 {
+			n, ok := val[0].(starlark.Int)
+			code, ok2 := n.Int64()
+			msg, ok3 := starlark.AsString(val[1])
+			if ok && ok2 && ok3 {
+				return lib.RetCode(code), msg, nil
+			}
+		}
+	}
+	return 0, "", fmt.Errorf("Invalid script result: %s!", v)
+}
@@ -0,0 +1,151 @@
+package callers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+const calcScript = `
+def build_req(ctx):
+    row = feed("operands")
+    state_incr("sent")
+    return json.encode({
+        "ID": ctx.id,
+        "Operands": [int(row["a"]), randInt(1, 10)],
+        "Operator": row["op"],
+    })
+
+def check_resp(req, resp):
+    body = parse_json(resp)
+    if body == None:
+        return (ERROR_RESPONSE, "response not json: " + resp)
+    if body.get("Err"):
+        return (ERROR_CALEE, body["Err"])
+    if json.decode(req)["ID"] != body["ID"]:
+        return (ERROR_RESPONSE, "response id mismatch")
+    return "ok " + body["Formula"]
+`
+
+func TestScriptCaller(t *testing.T) {
+	server := helper.NewTCPServer()
+	addr := "127.0.0.1:8086"
+	if err := server.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "loadgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "calc.star")
+	if err := ioutil.WriteFile(file, []byte(calcScript), 0644); err != nil {
+		t.Fatal(err)
+	}
+	caller, err := NewScriptCaller(ScriptConfig{
+		ScriptFile: file,
+		Transport:  helper.NewTCPComm(addr),
+		Feeds: map[string][]map[string]string{
+			"operands": {{"a": "3", "op": "+"}, {"a": "7", "op": "*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		result := call(caller, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS || !strings.HasPrefix(result.Msg, "ok ") {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+	}
+
+	script := caller.(*ScriptCaller)
+	if sent := script.state["sent"].String(); sent != "4" {
+		t.Fatalf("Unexpected state: sent=%s", sent)
+	}
+	result := script.CheckResp(lib.RawReq{Req: []byte(`{"ID":1}`)}, lib.RawResp{Resp: []byte(`{"ID":1,"Err":"boom"}`)})
+	if result.Code != lib.RET_CODE_ERROR_CALEE || result.Msg != "boom" {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	result = script.CheckResp(lib.RawReq{Req: []byte(`{"ID":1}`)}, lib.RawResp{Resp: []byte(`oops`)})
+	if result.Code != lib.RET_CODE_ERROR_RESPONSE {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	// 脚本执行出错时不引发恐慌
+	result = script.CheckResp(lib.RawReq{Req: []byte(`oops`)}, lib.RawResp{Resp: []byte(`{"ID":1}`)})
+	if result.Code != lib.RET_CODE_FATAL_CALL || !strings.Contains(result.Msg, "Script error") {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+}
+
+func TestScriptCallerErrors(t *testing.T) {
+	transport := helper.NewTCPComm("127.0.0.1:8086")
+	for name, src := range map[string]string{
+		"syntax":    "def build_req(ctx):\n  return (",
+		"no check":  "def build_req(ctx):\n  return ''",
+		"top level": "x = 1 // 0",
+	} {
+		if _, err := NewScriptCaller(ScriptConfig{Script: src, Transport: transport}); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	registerFeeder(t, "script.test", []lib.Record{{"id": "u1"}}, lib.FEED_SEQUENTIAL)
+	caller, err := NewScriptCaller(ScriptConfig{
+		Script: `
+counts = {}
+
+def build_req(ctx):
+    if ctx.seq == 1:
+        return feed("script.test")["id"]
+    if ctx.seq == 3:
+        counts["x"] = 1
+    if ctx.seq == 4:
+        return 42
+    return feed("script.test")["id"]
+
+def check_resp(req, resp):
+    return int(resp)
+`,
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := caller.(lib.FeederUser).Feeders(); len(names) != 1 || names[0] != "script.test" {
+		t.Fatalf("Unexpected feeders: %v", names)
+	}
+	if req := caller.BuildReq(); req.Err != nil || string(req.Req) != "u1" {
+		t.Fatalf("Unexpected request: %q, %v", req.Req, req.Err)
+	}
+	for _, want := range []string{"Feeder exhausted", "frozen", "must return a string"} {
+		req := caller.BuildReq()
+		if req.Err == nil || !strings.Contains(req.Err.Error(), want) {
+			t.Fatalf("Unexpected error: %v (want %q)", req.Err, want)
+		}
+	}
+	result := caller.CheckResp(lib.RawReq{}, lib.RawResp{Resp: []byte("2003")})
+	if result.Code != lib.RET_CODE_ERROR_CALEE {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+}
+
+// registerFeeder 注册测试用的数据源,已注册时从头读取,以便测试重复运行
+func registerFeeder(t *testing.T, name string, records []lib.Record, strategy lib.FeedStrategy) {
+	if feeder, ok := lib.LookupFeeder(name); ok {
+		feeder.Reset()
+		return
+	}
+	feeder, err := lib.NewFeeder(records, strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib.RegisterFeeder(name, feeder)
+}
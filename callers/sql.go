@@ -0,0 +1,229 @@
+package callers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// SQLQuery 表示一条参数化的查询
+type SQLQuery struct {
+	Name string   // 查询名称,用于结果分组,为空时使用序号
+	SQL  string   // SQL语句,占位符的写法取决于驱动
+	Args []string // 参数模板,可使用的数据见TemplateData,渲染结果以字符串传给驱动
+	Exec bool     // 是否以Exec执行(不返回行),否则以Query执行并读取全部行
+}
+
+// SQLConfig 表示数据库调用器的配置
+type SQLConfig struct {
+	Driver          string        // 已注册的database/sql驱动名称
+	DSN             string        // 数据源名称
+	Queries         []SQLQuery    // 查询,按请求序号轮流使用
+	MaxOpenConns    int           // 最大连接数,0表示不限制
+	MaxIdleConns    int           // 最大空闲连接数,0表示使用database/sql的默认值
+	ConnMaxLifetime time.Duration // 连接的最长使用时间,0表示不限制
+	ConnMaxIdleTime time.Duration // 连接的最长空闲时间,0表示不限制
+}
+
+// sqlReq 表示序列化在原生请求中的查询
+type sqlReq struct {
+	Query int      // 查询的序号
+	Args  []string // 渲染后的参数
+}
+
+// sqlResp 表示序列化在原生响应中的查询结果
+type sqlResp struct {
+	Rows     int64  // Query读取的行数
+	Affected int64  // Exec影响的行数,驱动不支持时为-1
+	Err      string `json:",omitempty"` // 驱动返回的错误
+}
+
+// SQLCaller 表示基于database/sql的调用器
+type SQLCaller struct {
+	cfg         SQLConfig
+	feederNames // 请求模板中使用的已注册数据源
+	db          *sql.DB
+	names       []string
+	args        [][]*template.Template
+	seq         int64 // 请求序号
+}
+
+// NewSQLCaller 新建一个数据库调用器,并检查数据库是否可以连接
+func NewSQLCaller(cfg SQLConfig) (lib.Caller, error) {
+	if cfg.Driver == "" {
+		return nil, errors.New("Invalid SQL driver!")
+	}
+	if len(cfg.Queries) == 0 {
+		return nil, errors.New("Invalid SQL queries!")
+	}
+	caller := &SQLCaller{cfg: cfg}
+	for i, q := range cfg.Queries {
+		if strings.TrimSpace(q.SQL) == "" {
+			return nil, fmt.Errorf("Invalid SQL statement of query #%d!", i)
+		}
+		name := q.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		var tmpls []*template.Template
+		for j, text := range q.Args {
+			tmpl, err := parseTemplate(fmt.Sprintf("%s.arg%d", name, j), text)
+			if err != nil {
+				return nil, err
+			}
+			tmpls = append(tmpls, tmpl)
+		}
+		caller.names = append(caller.names, name)
+		caller.args = append(caller.args, tmpls)
+	}
+	var all []*template.Template
+	for _, tmpls := range caller.args {
+		all = append(all, tmpls...)
+	}
+	caller.feederNames = templateFeeders(all...)
+	db, err := sql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	caller.db = db
+	return caller, nil
+}
+
+// Close 关闭数据库连接池
+func (caller *SQLCaller) Close() error {
+	return caller.db.Close()
+}
+
+// BuildReq 选择一条查询并渲染其参数
+func (caller *SQLCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	i := int((data.Seq - 1) % int64(len(caller.cfg.Queries)))
+	req := sqlReq{Query: i, Args: make([]string, len(caller.args[i]))}
+	r := &renderer{data: data}
+	for j, tmpl := range caller.args[i] {
+		req.Args[j] = r.render(tmpl)
+	}
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	return lib.RawReq{ID: data.ID, Req: reqBytes}
+}
+
+// Call 在超时时间内执行查询
+// 驱动返回的错误记录在响应中由CheckResp判定,超时则作为调用错误返回
+func (caller *SQLCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var r sqlReq
+	if err := json.Unmarshal(req, &r); err != nil {
+		return nil, err
+	}
+	if r.Query < 0 || r.Query >= len(caller.cfg.Queries) {
+		return nil, fmt.Errorf("Invalid query index: %d!", r.Query)
+	}
+	q := caller.cfg.Queries[r.Query]
+	args := make([]interface{}, len(r.Args))
+	for i, arg := range r.Args {
+		args[i] = arg
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutNS)
+	defer cancel()
+	resp, err := caller.execute(ctx, q, args)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		resp = &sqlResp{Err: err.Error()}
+	}
+	return json.Marshal(resp)
+}
+
+// execute 执行查询并统计行数
+func (caller *SQLCaller) execute(ctx context.Context, q SQLQuery, args []interface{}) (*sqlResp, error) {
+	if q.Exec {
+		result, err := caller.db.ExecContext(ctx, q.SQL, args...)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			affected = -1
+		}
+		return &sqlResp{Affected: affected}, nil
+	}
+	rows, err := caller.db.QueryContext(ctx, q.SQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &sqlResp{Rows: n}, nil
+}
+
+// CheckResp 检查查询结果,驱动错误以RET_CODE_ERROR_CALEE表示
+func (caller *SQLCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var req sqlReq
+	if err := json.Unmarshal(rawReq.Req, &req); err != nil || req.Query < 0 || req.Query >= len(caller.names) {
+		result.Code = lib.RET_CODE_FATAL_CALL
+		result.Msg = fmt.Sprintf("Incorrectly formatted Req: %s!", string(rawReq.Req))
+		return &result
+	}
+	result.SetTag("sql.query", caller.names[req.Query])
+
+	var resp sqlResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	if resp.Err != "" {
+		result.Code = lib.RET_CODE_ERROR_CALEE
+		result.Msg = fmt.Sprintf("Driver error: %s!", resp.Err)
+		return &result
+	}
+	result.Code = lib.RET_CODE_SUCCESS
+	if caller.cfg.Queries[req.Query].Exec {
+		result.SetTag("sql.affected", strconv.FormatInt(resp.Affected, 10))
+		result.Msg = fmt.Sprintf("Success. (affected=%d)", resp.Affected)
+	} else {
+		result.SetTag("sql.rows", strconv.FormatInt(resp.Rows, 10))
+		result.Msg = fmt.Sprintf("Success. (rows=%d)", resp.Rows)
+	}
+	return &result
+}
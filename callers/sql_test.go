@@ -0,0 +1,192 @@
+package callers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 测试用的驱动,在内存中维护一列整数,按语句前缀执行:
+// INSERT追加一个值,SELECT返回不大于参数的值,UPDATE把小于100的值改为参数,
+// FAIL返回错误,SLEEP等待到超时;占位符的数量由问号的个数确定
+func init() {
+	sql.Register("loadgen-fake", &fakeDriver{tables: make(map[string]*fakeTable)})
+}
+
+type fakeDriver struct {
+	mu     sync.Mutex
+	tables map[string]*fakeTable // 以DSN区分的数据
+}
+
+type fakeTable struct {
+	mu   sync.Mutex
+	vals []int64
+}
+
+func (d *fakeDriver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	table, ok := d.tables[dsn]
+	if !ok {
+		table = &fakeTable{}
+		d.tables[dsn] = table
+	}
+	return fakeConn{table}, nil
+}
+
+type fakeConn struct{ table *fakeTable }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.table, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	table *fakeTable
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+// arg 把第一个参数解析为整数
+func (s fakeStmt) arg(args []driver.Value) (int64, error) {
+	if len(args) == 0 {
+		return 0, errors.New("missing argument")
+	}
+	return strconv.ParseInt(args[0].(string), 10, 64)
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	v, err := s.arg(args)
+	if err != nil {
+		return nil, err
+	}
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.table.vals = append(s.table.vals, v)
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "UPDATE"):
+		var n int64
+		for i, old := range s.table.vals {
+			if old < 100 {
+				s.table.vals[i] = v
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, errors.New("syntax error")
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("use QueryContext")
+}
+
+func (s fakeStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case strings.HasPrefix(s.query, "SLEEP"):
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case !strings.HasPrefix(s.query, "SELECT"):
+		return nil, errors.New("syntax error")
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	max, err := s.arg(values)
+	if err != nil {
+		return nil, err
+	}
+	rows := &fakeRows{}
+	s.table.mu.Lock()
+	defer s.table.mu.Unlock()
+	for _, v := range s.table.vals {
+		if v <= max {
+			rows.vals = append(rows.vals, v)
+		}
+	}
+	return rows, nil
+}
+
+type fakeRows struct{ vals []int64 }
+
+func (r *fakeRows) Columns() []string { return []string{"v"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	dest[0], r.vals = r.vals[0], r.vals[1:]
+	return nil
+}
+
+func TestSQLCaller(t *testing.T) {
+	caller, err := NewSQLCaller(SQLConfig{
+		Driver: "loadgen-fake",
+		DSN:    "sql.test",
+		Queries: []SQLQuery{
+			{Name: "insert", SQL: "INSERT INTO t (v) VALUES (?)", Args: []string{"{{.Seq}}"}, Exec: true},
+			{Name: "select", SQL: "SELECT v FROM t WHERE v <= ?", Args: []string{"{{.Seq}}"}},
+			{Name: "update", SQL: "UPDATE t SET v = ? WHERE v < 100", Args: []string{"{{.Seq}}"}, Exec: true},
+			{Name: "fail", SQL: "FAIL"},
+		},
+		MaxOpenConns: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caller.(*SQLCaller).Close()
+	// 两轮查询:第二轮插入后表中有两行,SELECT读到两行,UPDATE影响两行
+	expected := []struct {
+		code lib.RetCode
+		key  string
+		tag  string
+	}{
+		{lib.RET_CODE_SUCCESS, "sql.affected", "1"},
+		{lib.RET_CODE_SUCCESS, "sql.rows", "1"},
+		{lib.RET_CODE_SUCCESS, "sql.affected", "1"},
+		{lib.RET_CODE_ERROR_CALEE, "sql.query", "fail"},
+		{lib.RET_CODE_SUCCESS, "sql.affected", "1"},
+		{lib.RET_CODE_SUCCESS, "sql.rows", "2"},
+		{lib.RET_CODE_SUCCESS, "sql.affected", "2"},
+		{lib.RET_CODE_ERROR_CALEE, "sql.query", "fail"},
+	}
+	for i, e := range expected {
+		result := call(caller, time.Second)
+		if result.Code != e.code || result.Tag(e.key) != e.tag {
+			t.Fatalf("Query %d: unexpected result: %v (tags=%v)", i, result, result.Tags)
+		}
+	}
+
+	// 参数个数与占位符不符时由database/sql报告驱动错误
+	bad, err := NewSQLCaller(SQLConfig{Driver: "loadgen-fake", Queries: []SQLQuery{{SQL: "SELECT v FROM t WHERE v <= ?"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.(*SQLCaller).Close()
+	if result := call(bad, time.Second); result.Code != lib.RET_CODE_ERROR_CALEE {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+
+	slow, err := NewSQLCaller(SQLConfig{Driver: "loadgen-fake", Queries: []SQLQuery{{SQL: "SLEEP"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.(*SQLCaller).Close()
+	if _, err := slow.Call(slow.BuildReq().Req, 20*time.Millisecond); err != context.DeadlineExceeded {
+		t.Fatalf("Expected a deadline error, got: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+package callers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"loadgen/lib"
+)
+
+// streamMaxFrame 表示可接收的最大帧长度
+const streamMaxFrame = 16 << 20
+
+// Framer 代表流式连接上的分帧方式
+// 自定义的二进制协议可通过实现该接口接入
+type Framer interface {
+	// 写入一帧,由调用方负责刷新缓冲区
+	WriteFrame(w *bufio.Writer, payload []byte) error
+	// 读取一帧,返回去掉分帧信息后的内容
+	ReadFrame(r *bufio.Reader) ([]byte, error)
+}
+
+// delimFramer 表示以分隔符结尾的分帧方式
+type delimFramer struct {
+	delim byte
+}
+
+// NewDelimFramer 新建一个以分隔符结尾的分帧方式,内容中不能包含分隔符
+func NewDelimFramer(delim byte) Framer {
+	return delimFramer{delim: delim}
+}
+
+// WriteFrame 写入内容并追加分隔符
+func (f delimFramer) WriteFrame(w *bufio.Writer, payload []byte) error {
+	if bytes.IndexByte(payload, f.delim) >= 0 {
+		return fmt.Errorf("Payload contains the delimiter %q!", f.delim)
+	}
+	w.Write(payload)
+	return w.WriteByte(f.delim)
+}
+
+// ReadFrame 读取到分隔符为止的内容
+func (f delimFramer) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := r.ReadSlice(f.delim)
+		if len(frame)+len(chunk) > streamMaxFrame+1 {
+			return nil, fmt.Errorf("Frame too large! (max=%d)", streamMaxFrame)
+		}
+		frame = append(frame, chunk...)
+		if err == nil {
+			return frame[:len(frame)-1], nil
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+}
+
+// lengthFramer 表示以定长的长度字段开头的分帧方式
+type lengthFramer struct {
+	size  int // 长度字段的字节数,取值为2或4
+	order binary.ByteOrder
+}
+
+// NewLengthFramer 新建一个以长度字段开头的分帧方式
+// 参数size为长度字段的字节数,取值为2或4;参数order为字节序,如binary.BigEndian
+func NewLengthFramer(size int, order binary.ByteOrder) (Framer, error) {
+	if size != 2 && size != 4 {
+		return nil, fmt.Errorf("Invalid length prefix size: %d!", size)
+	}
+	if order == nil {
+		return nil, errors.New("Invalid byte order!")
+	}
+	return lengthFramer{size: size, order: order}, nil
+}
+
+// WriteFrame 写入长度字段和内容
+func (f lengthFramer) WriteFrame(w *bufio.Writer, payload []byte) error {
+	var prefix [4]byte
+	if f.size == 2 {
+		if len(payload) > 0xffff {
+			return fmt.Errorf("Payload too large for a 2-byte length prefix! (len=%d)", len(payload))
+		}
+		f.order.PutUint16(prefix[:], uint16(len(payload)))
+	} else {
+		if uint64(len(payload)) > 0xffffffff {
+			return fmt.Errorf("Payload too large for a 4-byte length prefix! (len=%d)", len(payload))
+		}
+		f.order.PutUint32(prefix[:], uint32(len(payload)))
+	}
+	w.Write(prefix[:f.size])
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame 读取长度字段及其指定长度的内容
+func (f lengthFramer) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:f.size]); err != nil {
+		return nil, err
+	}
+	var n int
+	if f.size == 2 {
+		n = int(f.order.Uint16(prefix[:]))
+	} else {
+		n = int(f.order.Uint32(prefix[:]))
+	}
+	if n > streamMaxFrame {
+		return nil, fmt.Errorf("Frame too large! (len=%d, max=%d)", n, streamMaxFrame)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// fixedFramer 表示固定长度的分帧方式
+type fixedFramer struct {
+	size int
+}
+
+// NewFixedFramer 新建一个固定长度的分帧方式,较短的内容以零字节补齐
+func NewFixedFramer(size int) (Framer, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("Invalid frame size: %d!", size)
+	}
+	return fixedFramer{size: size}, nil
+}
+
+// WriteFrame 写入内容并以零字节补齐到固定长度
+func (f fixedFramer) WriteFrame(w *bufio.Writer, payload []byte) error {
+	if len(payload) > f.size {
+		return fmt.Errorf("Payload exceeds the frame size! (%d>%d)", len(payload), f.size)
+	}
+	w.Write(payload)
+	_, err := w.Write(make([]byte, f.size-len(payload)))
+	return err
+}
+
+// ReadFrame 读取固定长度的内容
+func (f fixedFramer) ReadFrame(r *bufio.Reader) ([]byte, error) {
+	frame := make([]byte, f.size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// StreamConfig 表示流式调用器的配置
+type StreamConfig struct {
+	Addr   string // 服务地址,形如host:port,也可以是"unix:///path/to.sock"
+	Framer Framer // 分帧方式
+	// Encode 依据请求ID和序号生成请求内容
+	Encode func(id, seq int64) ([]byte, error)
+	// Decode 检查请求对应的响应内容,返回结果代码和说明;为nil时收到响应即视为成功
+	Decode func(req, resp []byte) (lib.RetCode, string)
+	// Pool 连接池配置,为nil时每次调用新建连接
+	Pool *lib.PoolConfig
+}
+
+// bufferedConn 表示带读缓冲区的连接,缓冲区随连接一起被复用
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// StreamCaller 表示基于流式连接的通用调用器
+type StreamCaller struct {
+	cfg  StreamConfig
+	pool lib.ConnPool
+	seq  int64 // 请求序号
+}
+
+// NewStreamCaller 新建一个流式调用器
+func NewStreamCaller(cfg StreamConfig) (lib.Caller, error) {
+	if _, _, err := lib.SplitNetworkAddr(cfg.Addr); err != nil {
+		return nil, err
+	}
+	if cfg.Framer == nil {
+		return nil, errors.New("Invalid framer!")
+	}
+	if cfg.Encode == nil {
+		return nil, errors.New("Invalid encode function!")
+	}
+	caller := &StreamCaller{cfg: cfg}
+	if cfg.Pool != nil {
+		pool, err := lib.NewConnPool(caller.dial, *cfg.Pool)
+		if err != nil {
+			return nil, err
+		}
+		caller.pool = pool
+	}
+	return caller, nil
+}
+
+// dial 建立一个带读缓冲区的连接
+func (caller *StreamCaller) dial(timeoutNS time.Duration) (net.Conn, error) {
+	network, address, err := lib.SplitNetworkAddr(caller.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout(network, address, timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Close 关闭调用器持有的空闲连接
+func (caller *StreamCaller) Close() {
+	if caller.pool != nil {
+		caller.pool.Close()
+	}
+}
+
+// BuildReq 使用编码函数构建一个请求
+func (caller *StreamCaller) BuildReq() lib.RawReq {
+	id := time.Now().UnixNano()
+	req, err := caller.cfg.Encode(id, atomic.AddInt64(&caller.seq, 1))
+	if err != nil {
+		return lib.RawReq{ID: id, Err: err}
+	}
+	return lib.RawReq{ID: id, Req: req}
+}
+
+// EditRequest 原生请求即为发出的载荷,协议不支持头部
+func (caller *StreamCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	return edit(req, nil)
+}
+
+// Call 发送一帧请求并读取一帧响应
+func (caller *StreamCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeoutNS)
+	if caller.pool == nil {
+		conn, err := caller.dial(timeoutNS)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		conn.SetDeadline(deadline)
+		return caller.roundTrip(conn.(*bufferedConn), req)
+	}
+	conn, err := caller.pool.Get(timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	resp, err := caller.roundTrip(conn.Conn.(*bufferedConn), req)
+	caller.pool.Put(conn, err != nil)
+	return resp, err
+}
+
+// roundTrip 在连接上写入一帧并读取一帧
+func (caller *StreamCaller) roundTrip(conn *bufferedConn, req []byte) ([]byte, error) {
+	w := bufio.NewWriter(conn.Conn)
+	if err := caller.cfg.Framer.WriteFrame(w, req); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return caller.cfg.Framer.ReadFrame(conn.r)
+}
+
+// CheckResp 使用解码函数检查响应
+func (caller *StreamCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+	if caller.cfg.Decode == nil {
+		result.Code = lib.RET_CODE_SUCCESS
+		result.Msg = fmt.Sprintf("Success. (%d bytes)", len(rawResp.Resp))
+		return &result
+	}
+	result.Code, result.Msg = caller.cfg.Decode(rawReq.Req, rawResp.Resp)
+	return &result
+}
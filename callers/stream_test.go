@@ -0,0 +1,145 @@
+package callers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+// serveFrames 启动一个按给定分帧方式回显请求的服务器
+func serveFrames(t *testing.T, framer Framer) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				w := bufio.NewWriter(conn)
+				for {
+					frame, err := framer.ReadFrame(r)
+					if err != nil {
+						return
+					}
+					framer.WriteFrame(w, bytes.ToUpper(frame))
+					w.Flush()
+				}
+			}(conn)
+		}
+	}()
+	return ln
+}
+
+func TestStreamCallerFramers(t *testing.T) {
+	be2, _ := NewLengthFramer(2, binary.BigEndian)
+	le4, _ := NewLengthFramer(4, binary.LittleEndian)
+	fixed, _ := NewFixedFramer(16)
+	framers := map[string]Framer{
+		"delim": NewDelimFramer(0),
+		"be2":   be2,
+		"le4":   le4,
+		"fixed": fixed,
+	}
+	for name, framer := range framers {
+		ln := serveFrames(t, framer)
+		caller, err := NewStreamCaller(StreamConfig{
+			Addr:   ln.Addr().String(),
+			Framer: framer,
+			Encode: func(id, seq int64) ([]byte, error) {
+				return []byte(fmt.Sprintf("msg-%d", seq)), nil
+			},
+			Decode: func(req, resp []byte) (lib.RetCode, string) {
+				want := bytes.ToUpper(req)
+				if !bytes.Equal(bytes.TrimRight(resp, "\x00"), want) {
+					return lib.RET_CODE_ERROR_RESPONSE, fmt.Sprintf("%q != %q", resp, want)
+				}
+				return lib.RET_CODE_SUCCESS, "Success."
+			},
+			Pool: &lib.PoolConfig{Size: 1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			result := call(caller, time.Second)
+			if result.Code != lib.RET_CODE_SUCCESS {
+				t.Fatalf("%s: unexpected result: %v", name, result)
+			}
+		}
+		caller.(*StreamCaller).Close()
+		ln.Close()
+	}
+
+	if _, err := NewLengthFramer(3, binary.BigEndian); err == nil {
+		t.Fatal("Expected an error with invalid length prefix size!")
+	}
+	var buf bytes.Buffer
+	if err := be2.WriteFrame(bufio.NewWriter(&buf), make([]byte, 0x10000)); err == nil {
+		t.Fatal("Expected an error with oversized payload!")
+	}
+
+	// 超过最大帧长度的帧在分配内存之前即被拒绝
+	huge := bufio.NewReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	if _, err := le4.ReadFrame(huge); err == nil {
+		t.Fatal("Expected an error with oversized length prefix!")
+	}
+	endless := bufio.NewReader(io.LimitReader(neverDelim{}, streamMaxFrame+2))
+	if _, err := NewDelimFramer('\n').ReadFrame(endless); err == nil || err == io.EOF {
+		t.Fatalf("Expected a frame size error without delimiter, got %v", err)
+	}
+}
+
+// neverDelim 表示不断产生不含分隔符的内容的读取器
+type neverDelim struct{}
+
+func (neverDelim) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestStreamCallerTCPServer(t *testing.T) {
+	server := helper.NewTCPServer()
+	addr := "127.0.0.1:8084"
+	if err := server.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	caller, err := NewStreamCaller(StreamConfig{
+		Addr:   addr,
+		Framer: NewDelimFramer('\n'),
+		Encode: func(id, seq int64) ([]byte, error) {
+			return json.Marshal(helper.ServerReq{ID: id, Operands: []int{int(seq), 2}, Operator: "*"})
+		},
+		Decode: func(req, resp []byte) (lib.RetCode, string) {
+			var sResp helper.ServerResp
+			if err := json.Unmarshal(resp, &sResp); err != nil {
+				return lib.RET_CODE_ERROR_RESPONSE, err.Error()
+			}
+			return lib.RET_CODE_SUCCESS, sResp.Formula
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := call(caller, time.Second)
+	if result.Code != lib.RET_CODE_SUCCESS || result.Msg != "1 * 2 = 2" {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+}
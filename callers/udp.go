@@ -0,0 +1,144 @@
+package callers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// UDPConfig 表示UDP调用器的配置
+type UDPConfig struct {
+	Addr             string        // 服务地址,形如host:port
+	Message          string        // 数据报模板,可使用的数据见TemplateData
+	WaitReply        bool          // 是否等待回复,为false时发出数据报即视为成功
+	CorrelationField string        // 用于匹配请求和回复的JSON字段名,为空时以收到的第一个数据报作为回复
+	ReplyTimeout     time.Duration // 等待回复的时间,超过则视为丢失;为0时取调用超时时间的9/10
+	MaxDatagram      int           // 可接收的最大数据报长度,默认为65535
+}
+
+// udpResp 表示序列化在原生响应中的UDP响应
+type udpResp struct {
+	Lost  bool   // 数据报是否丢失
+	Reply []byte // 回复
+}
+
+// UDPCaller 表示UDP调用器
+type UDPCaller struct {
+	cfg         UDPConfig
+	feederNames // 请求模板中使用的已注册数据源
+	tmpl        *template.Template
+	seq         int64 // 请求序号
+}
+
+// NewUDPCaller 新建一个UDP调用器
+func NewUDPCaller(cfg UDPConfig) (lib.Caller, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("Invalid UDP address!")
+	}
+	if cfg.MaxDatagram <= 0 {
+		cfg.MaxDatagram = 65535
+	}
+	tmpl, err := parseTemplate("message", cfg.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &UDPCaller{cfg: cfg, tmpl: tmpl, feederNames: templateFeeders(tmpl)}, nil
+}
+
+// BuildReq 依据模板构建一个数据报
+func (caller *UDPCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	r := &renderer{data: data}
+	req := r.render(caller.tmpl)
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	return lib.RawReq{ID: data.ID, Req: []byte(req)}
+}
+
+// EditRequest 原生请求即为发出的载荷,协议不支持头部
+func (caller *UDPCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	return edit(req, nil)
+}
+
+// Call 发送一个数据报,并在需要时等待与之匹配的回复
+// 在等待时间内未收到回复时不返回错误,而是在响应中标记为丢失
+func (caller *UDPCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var corrID string
+	if caller.cfg.WaitReply && caller.cfg.CorrelationField != "" {
+		var ok bool
+		if corrID, ok = correlationID(req, caller.cfg.CorrelationField); !ok {
+			return nil, fmt.Errorf("Missing correlation field %q in datagram!", caller.cfg.CorrelationField)
+		}
+	}
+	conn, err := net.DialTimeout("udp", caller.cfg.Addr, timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	if !caller.cfg.WaitReply {
+		return json.Marshal(udpResp{})
+	}
+	wait := caller.cfg.ReplyTimeout
+	if wait <= 0 {
+		wait = timeoutNS * 9 / 10
+	}
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, caller.cfg.MaxDatagram)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return json.Marshal(udpResp{Lost: true})
+			}
+			return nil, err
+		}
+		reply := buf[:n]
+		if caller.cfg.CorrelationField != "" {
+			if id, ok := correlationID(reply, caller.cfg.CorrelationField); !ok || id != corrID {
+				continue
+			}
+		}
+		return json.Marshal(udpResp{Reply: reply})
+	}
+}
+
+// CheckResp 检查回复,丢失的数据报以RET_CODE_WARNING_DATAGRAM_LOST表示
+func (caller *UDPCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var resp udpResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	if resp.Lost {
+		result.Code = lib.RET_CODE_WARNING_DATAGRAM_LOST
+		result.Msg = "Datagram lost!"
+		return &result
+	}
+	result.Code = lib.RET_CODE_SUCCESS
+	if caller.cfg.WaitReply {
+		result.Msg = fmt.Sprintf("Success. (%s)", string(resp.Reply))
+	} else {
+		result.Msg = "Success. (sent)"
+	}
+	return &result
+}
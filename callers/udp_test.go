@@ -0,0 +1,37 @@
+package callers
+
+import (
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+func TestUDPCaller(t *testing.T) {
+	server := helper.NewUDPServer()
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.SetDropEvery(2)
+
+	caller, err := NewUDPCaller(UDPConfig{
+		Addr:             server.Addr().String(),
+		Message:          `{"ID":{{.ID}},"Operands":[{{.Seq}},2],"Operator":"+"}`,
+		WaitReply:        true,
+		CorrelationField: "ID",
+		ReplyTimeout:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	countMap := make(map[lib.RetCode]int)
+	for i := 0; i < 4; i++ {
+		result := call(caller, time.Second)
+		countMap[result.Code]++
+	}
+	if countMap[lib.RET_CODE_SUCCESS] != 2 || countMap[lib.RET_CODE_WARNING_DATAGRAM_LOST] != 2 {
+		t.Fatalf("Unexpected result codes: %v", countMap)
+	}
+}
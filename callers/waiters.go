@@ -0,0 +1,90 @@
+package callers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errConnBroken 表示共享的连接已断开
+var errConnBroken = errors.New("The shared connection is broken!")
+
+// waitResult 表示一次等待的结果
+type waitResult struct {
+	data []byte
+	err  error
+}
+
+// waiters 表示共享连接上按键等待回复的调用,由连接的读协程负责唤醒
+type waiters struct {
+	mu  sync.Mutex
+	m   map[string]chan waitResult
+	err error // 连接断开的原因,不为nil时不再接受新的等待
+}
+
+// newWaiters 新建一组等待
+func newWaiters() *waiters {
+	return &waiters{m: make(map[string]chan waitResult)}
+}
+
+// add 登记一个等待,必须在发出请求之前调用
+func (ws *waiters) add(key string) (chan waitResult, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.err != nil {
+		return nil, ws.err
+	}
+	if _, ok := ws.m[key]; ok {
+		return nil, fmt.Errorf("Duplicate wait key: %s!", key)
+	}
+	ch := make(chan waitResult, 1)
+	ws.m[key] = ch
+	return ch, nil
+}
+
+// remove 取消一个等待
+func (ws *waiters) remove(key string) {
+	ws.mu.Lock()
+	delete(ws.m, key)
+	ws.mu.Unlock()
+}
+
+// resolve 唤醒键对应的等待,没有等待时返回false
+func (ws *waiters) resolve(key string, data []byte) bool {
+	ws.mu.Lock()
+	ch, ok := ws.m[key]
+	delete(ws.m, key)
+	ws.mu.Unlock()
+	if ok {
+		ch <- waitResult{data: data}
+	}
+	return ok
+}
+
+// fail 以错误结束所有等待,之后的等待均直接返回该错误
+func (ws *waiters) fail(err error) {
+	ws.mu.Lock()
+	if ws.err == nil {
+		ws.err = err
+	}
+	m := ws.m
+	ws.m = make(map[string]chan waitResult)
+	ws.mu.Unlock()
+	for _, ch := range m {
+		ch <- waitResult{err: err}
+	}
+}
+
+// wait 在截止时间之前等待键对应的结果,超时只取消这一个等待
+func (ws *waiters) wait(key string, ch chan waitResult, deadline time.Time) ([]byte, error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case result := <-ch:
+		return result.data, result.err
+	case <-timer.C:
+		ws.remove(key)
+		return nil, fmt.Errorf("Timeout waiting for %s!", key)
+	}
+}
@@ -0,0 +1,440 @@
+package callers
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 调用结果的WebSocket相关标签
+const (
+	TAG_WS_CONNECT = "ws.connect" // 建立连接(含握手)的耗时,仅在本次调用新建连接时设置
+	TAG_WS_RTT     = "ws.rtt"     // 消息往返耗时
+)
+
+// WebSocket帧的操作码
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsGUID 表示计算Sec-WebSocket-Accept时使用的GUID
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsMaxMessage 表示可接收的最大消息长度
+const wsMaxMessage = 16 << 20
+
+// WebSocketConfig 表示WebSocket调用器的配置
+type WebSocketConfig struct {
+	URL              string            // 服务地址,形如ws://host/path或wss://host/path
+	Header           map[string]string // 握手请求头
+	Message          string            // 消息模板,可使用的数据见TemplateData
+	CorrelationField string            // 用于匹配请求和回复的JSON字段名,为空时以收到的第一条消息作为回复,使用长连接时必须设置
+	Conns            int               // 长连接数,即虚拟用户数;为0时每次调用新建连接
+	TLSConfig        *tls.Config       // TLS配置,仅用于wss
+}
+
+// wsResp 表示序列化在原生响应中的WebSocket响应
+type wsResp struct {
+	Message []byte
+	Connect time.Duration // 建立连接的耗时,复用连接时为0
+	RTT     time.Duration // 消息往返耗时
+}
+
+// WebSocketCaller 表示WebSocket调用器
+type WebSocketCaller struct {
+	cfg         WebSocketConfig
+	feederNames // 请求模板中使用的已注册数据源
+	url         *url.URL
+	tmpl        *template.Template
+	conns       chan *wsConn // 空闲的长连接,元素为nil时表示需要新建连接
+	seq         int64        // 请求序号
+}
+
+// wsConn 表示一个客户端WebSocket连接
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// NewWebSocketCaller 新建一个WebSocket调用器
+func NewWebSocketCaller(cfg WebSocketConfig) (lib.Caller, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WebSocket URL: %s", err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("Invalid WebSocket URL scheme: %s!", u.Scheme)
+	}
+	// 长连接上可能残留服务端推送的消息或上一条消息的多余回复,不能以收到的第一条消息作为回复
+	if cfg.Conns > 0 && cfg.CorrelationField == "" {
+		return nil, errors.New("WebSocket correlation field is required with persistent connections!")
+	}
+	tmpl, err := parseTemplate("message", cfg.Message)
+	if err != nil {
+		return nil, err
+	}
+	caller := &WebSocketCaller{cfg: cfg, url: u, tmpl: tmpl, feederNames: templateFeeders(tmpl)}
+	if cfg.Conns > 0 {
+		caller.conns = make(chan *wsConn, cfg.Conns)
+		for i := 0; i < cfg.Conns; i++ {
+			caller.conns <- nil
+		}
+	}
+	return caller, nil
+}
+
+// BuildReq 依据模板构建一条消息
+func (caller *WebSocketCaller) BuildReq() lib.RawReq {
+	data := &TemplateData{
+		ID:   time.Now().UnixNano(),
+		Seq:  atomic.AddInt64(&caller.seq, 1),
+		Time: time.Now().UnixNano(),
+	}
+	r := &renderer{data: data}
+	req := r.render(caller.tmpl)
+	if r.err != nil {
+		return lib.RawReq{ID: data.ID, Err: r.err}
+	}
+	return lib.RawReq{ID: data.ID, Req: []byte(req)}
+}
+
+// EditRequest 原生请求即为发出的载荷,协议不支持头部
+func (caller *WebSocketCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	return edit(req, nil)
+}
+
+// Call 发送一条消息并等待与之匹配的回复
+func (caller *WebSocketCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeoutNS)
+	var corrID string
+	if caller.cfg.CorrelationField != "" {
+		var ok bool
+		if corrID, ok = correlationID(req, caller.cfg.CorrelationField); !ok {
+			return nil, fmt.Errorf("Missing correlation field %q in message!", caller.cfg.CorrelationField)
+		}
+	}
+
+	var resp wsResp
+	var conn *wsConn
+	if caller.conns != nil {
+		timer := time.NewTimer(timeoutNS)
+		select {
+		case conn = <-caller.conns:
+			timer.Stop()
+		case <-timer.C:
+			return nil, fmt.Errorf("Timeout waiting for an idle WebSocket connection! (conns=%d)", caller.cfg.Conns)
+		}
+	}
+	if conn == nil {
+		start := time.Now()
+		c, err := caller.dial(deadline)
+		if err != nil {
+			caller.release(nil)
+			return nil, err
+		}
+		conn = c
+		resp.Connect = time.Since(start)
+	}
+
+	start := time.Now()
+	msg, err := conn.roundTrip(req, caller.cfg.CorrelationField, corrID, deadline)
+	resp.RTT = time.Since(start)
+	if err != nil {
+		conn.conn.Close()
+		caller.release(nil)
+		return nil, err
+	}
+	if caller.conns != nil {
+		caller.release(conn)
+	} else {
+		conn.close()
+	}
+	resp.Message = msg
+	return json.Marshal(resp)
+}
+
+// release 归还长连接,conn为nil表示连接已失效
+func (caller *WebSocketCaller) release(conn *wsConn) {
+	if caller.conns != nil {
+		caller.conns <- conn
+	}
+}
+
+// dial 建立连接并完成握手
+func (caller *WebSocketCaller) dial(deadline time.Time) (*wsConn, error) {
+	host := caller.url.Host
+	if caller.url.Port() == "" {
+		if caller.url.Scheme == "wss" {
+			host = net.JoinHostPort(caller.url.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(caller.url.Hostname(), "80")
+		}
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if caller.url.Scheme == "wss" {
+		cfg := caller.cfg.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = caller.url.Hostname()
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, cfg)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	c := &wsConn{conn: conn, br: bufio.NewReader(conn)}
+	if err := c.handshake(caller.url, caller.cfg.Header); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake 发送升级请求并校验服务端的应答
+func (c *wsConn) handshake(u *url.URL, header map[string]string) error {
+	var nonce [16]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(c.conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(c.br, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("WebSocket handshake failed: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return errors.New("WebSocket handshake failed: invalid Sec-WebSocket-Accept")
+	}
+	return nil
+}
+
+// wsAcceptKey 计算与Sec-WebSocket-Key对应的Sec-WebSocket-Accept
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// roundTrip 发送一条文本消息并读取与之匹配的回复,不匹配的消息将被丢弃
+func (c *wsConn) roundTrip(msg []byte, field, corrID string, deadline time.Time) ([]byte, error) {
+	c.conn.SetDeadline(deadline)
+	if err := wsWriteFrame(c.conn, wsOpText, msg, true); err != nil {
+		return nil, err
+	}
+	for {
+		reply, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if field == "" {
+			return reply, nil
+		}
+		if id, ok := correlationID(reply, field); ok && id == corrID {
+			return reply, nil
+		}
+	}
+}
+
+// readMessage 读取一条完整的数据消息,并处理期间收到的控制帧
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := wsReadFrame(c.br)
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := wsWriteFrame(c.conn, wsOpPong, payload, true); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return nil, errors.New("WebSocket connection closed by peer")
+		}
+		msg = append(msg, payload...)
+		if len(msg) > wsMaxMessage {
+			return nil, errors.New("WebSocket message too large")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// close 发送关闭帧并关闭连接
+func (c *wsConn) close() {
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	wsWriteFrame(c.conn, wsOpClose, []byte{0x03, 0xE8}, true)
+	c.conn.Close()
+}
+
+// correlationID 从JSON消息中取出关联字段的值
+func correlationID(msg []byte, field string) (string, bool) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(msg, &obj); err != nil {
+		return "", false
+	}
+	v, ok := obj[field]
+	if !ok {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	b, _ := json.Marshal(v)
+	return string(b), true
+}
+
+// wsWriteFrame 写出一个不分片的帧,客户端发出的帧必须掩码
+func wsWriteFrame(w io.Writer, opcode byte, payload []byte, mask bool) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | opcode
+	n := len(payload)
+	switch {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if mask {
+		header[1] |= 0x80
+		var key [4]byte
+		if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+			return err
+		}
+		header = append(header, key[:]...)
+		masked := make([]byte, n)
+		for i := range payload {
+			masked[i] = payload[i] ^ key[i%4]
+		}
+		payload = masked
+	}
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// wsReadFrame 读取一个帧,返回是否为最后一个分片、操作码和去除掩码后的负载
+func wsReadFrame(r io.Reader) (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// CheckResp 检查回复并记录连接耗时和消息往返耗时
+func (caller *WebSocketCaller) CheckResp(rawReq lib.RawReq, rawResp lib.RawResp) *lib.CallResult {
+	var result lib.CallResult
+	result.ID = rawReq.ID
+	result.Req = rawReq
+	result.Resp = rawResp
+
+	var resp wsResp
+	if err := json.Unmarshal(rawResp.Resp, &resp); err != nil {
+		result.Code = lib.RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted Resp: %s!", string(rawResp.Resp))
+		return &result
+	}
+	if resp.Connect > 0 {
+		result.SetTag(TAG_WS_CONNECT, resp.Connect.String())
+	}
+	result.SetTag(TAG_WS_RTT, resp.RTT.String())
+	result.Code = lib.RET_CODE_SUCCESS
+	result.Msg = fmt.Sprintf("Success. (rtt=%v)", resp.RTT)
+	return &result
+}
@@ -0,0 +1,108 @@
+package callers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+)
+
+// testWebSocketHandler 表示进程内的WebSocket服务,每条消息回复前先推送一条无关消息
+func testWebSocketHandler(conns *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(conns, 1)
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		brw.Flush()
+		for {
+			_, opcode, payload, err := wsReadFrame(brw)
+			if err != nil || opcode == wsOpClose {
+				return
+			}
+			if opcode == wsOpPong {
+				continue
+			}
+			wsWriteFrame(conn, wsOpPing, []byte("hi"), false)
+			wsWriteFrame(conn, wsOpText, []byte(`{"id":"push"}`), false)
+			wsWriteFrame(conn, wsOpText, payload, false)
+		}
+	})
+}
+
+func TestWebSocketCaller(t *testing.T) {
+	var conns int32
+	server := httptest.NewServer(testWebSocketHandler(&conns))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/echo"
+
+	// 长连接模式
+	caller, err := NewWebSocketCaller(WebSocketConfig{
+		URL:              wsURL,
+		Message:          `{"id":"{{.Seq}}","op":"echo"}`,
+		CorrelationField: "id",
+		Conns:            1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		result := call(caller, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS || result.Tag(TAG_WS_RTT) == "" {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+		if _, ok := result.Tags[TAG_WS_CONNECT]; ok != (i == 0) {
+			t.Fatalf("Unexpected connect tag in call %d: %v", i, result.Tags)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("Unexpected connection count: %d", n)
+	}
+
+	// 全部长连接都在使用中时,等待空闲连接不超过调用超时时间
+	held := <-caller.(*WebSocketCaller).conns
+	start := time.Now()
+	if result := call(caller, 50*time.Millisecond); result.Code != lib.RET_CODE_ERROR_CALL {
+		t.Fatalf("Unexpected result while the pool is busy: %v", result)
+	}
+	if elapse := time.Since(start); elapse > 500*time.Millisecond {
+		t.Fatalf("Waited too long for an idle connection: %v", elapse)
+	}
+	caller.(*WebSocketCaller).conns <- held
+
+	// 长连接上无法以第一条消息作为回复
+	if _, err := NewWebSocketCaller(WebSocketConfig{URL: wsURL, Conns: 1}); err == nil {
+		t.Fatal("Expected an error without correlation field!")
+	}
+
+	// 每次调用新建连接
+	caller, err = NewWebSocketCaller(WebSocketConfig{
+		URL:     wsURL,
+		Message: `{"id":"{{.Seq}}"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		result := call(caller, time.Second)
+		if result.Code != lib.RET_CODE_SUCCESS || result.Tag(TAG_WS_CONNECT) == "" {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 3 {
+		t.Fatalf("Unexpected connection count: %d", n)
+	}
+}
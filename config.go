@@ -0,0 +1,274 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 默认的调用结果通道容量
+const DEFAULT_RESULT_BUFFER = 50
+
+// Duration 表示可以用"30s"、"500ms"等可读形式配置的时长
+type Duration time.Duration
+
+// parseDuration 解析可读形式(如"30s")或不带单位的数值(秒,如"30"或"0.5")的时长
+func parseDuration(s string) (Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return Duration(secs * float64(time.Second)), nil
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return Duration(dur), nil
+}
+
+// UnmarshalJSON 从JSON字符串(如"30s"或"30")或数值(秒,如30或0.5)解码时长
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case string:
+		dur, err := parseDuration(val)
+		if err != nil {
+			return err
+		}
+		*d = dur
+	case float64:
+		*d = Duration(val * float64(time.Second))
+	default:
+		return fmt.Errorf("invalid duration %s", string(data))
+	}
+	return nil
+}
+
+// MarshalJSON 把时长编码为可读形式的字符串
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Thresholds 表示判定一次负载测试是否通过的阈值
+type Thresholds struct {
+	MaxErrorRate float64  `json:"max_error_rate"` // 最大错误率,取值范围[0, 1]
+	MaxAvgElapse Duration `json:"max_avg_elapse"` // 最大平均耗时
+}
+
+// Evaluate 依据调用结果判定负载测试是否通过,未通过时返回值的类型为ParamErrors,其中包含全部超出的阈值
+// 非成功的结果均计为错误,为0的阈值不参与判定
+func (t Thresholds) Evaluate(results []*lib.CallResult) error {
+	var errs ParamErrors
+	var total, failed int
+	var elapse time.Duration
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		total++
+		if r.Code != lib.RET_CODE_SUCCESS {
+			failed++
+		}
+		elapse += r.Elapse
+	}
+	if total == 0 {
+		errs.add("results", "No call results to evaluate!")
+		return errs
+	}
+	if rate := float64(failed) / float64(total); t.MaxErrorRate > 0 && rate > t.MaxErrorRate {
+		errs.add("thresholds.max_error_rate", "Error rate %.4f exceeds %v! (failed=%d, total=%d)",
+			rate, t.MaxErrorRate, failed, total)
+	}
+	if avg := elapse / time.Duration(total); t.MaxAvgElapse > 0 && avg > time.Duration(t.MaxAvgElapse) {
+		errs.add("thresholds.max_avg_elapse", "Average elapse %v exceeds %v!", avg, time.Duration(t.MaxAvgElapse))
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// Config 表示声明式的载荷发生器配置
+type Config struct {
+	Target       string            `json:"target"`        // 载荷承受方地址
+	Caller       string            `json:"caller"`        // 调用器类型
+	Options      lib.Options       `json:"options"`       // 调用器选项
+	Timeout      Duration          `json:"timeout"`       // 响应超时时间
+	LPS          uint32            `json:"lps"`           // 每秒载荷数
+	Duration     Duration          `json:"duration"`      // 负载持续时间
+	Profile      string            `json:"profile"`       // 负载模型,目前只支持PROFILE_CONSTANT,为空时即为该模型
+	Thresholds   Thresholds        `json:"thresholds"`    // 通过阈值
+	ResultBuffer int               `json:"result_buffer"` // 调用结果通道容量
+	Tags         map[string]string `json:"tags"`          // 附加到调用结果上的标签
+}
+
+// CallerBuilder 表示依据调用器类型、目标地址和选项构建调用器的函数
+type CallerBuilder func(callerType string, target string, opts lib.Options) (lib.Caller, error)
+
+// RegistryBuilder 从调用器注册表中构建调用器,调用器类型即目标URL的协议名
+// 目标地址不含协议名时以调用器类型补全,如类型tcp和地址127.0.0.1:8080
+// 使用前需导入注册调用器的包,如loadgen/callers
+func RegistryBuilder(callerType string, target string, opts lib.Options) (lib.Caller, error) {
+	if i := strings.Index(target, "://"); i < 0 {
+		target = callerType + "://" + target
+	} else if !strings.EqualFold(target[:i], callerType) {
+		return nil, fmt.Errorf("Caller type %q does not match the target scheme %q!", callerType, target[:i])
+	}
+	return lib.NewCaller(target, opts)
+}
+
+// LoadConfig 从YAML或JSON文件加载配置,格式依据文件扩展名判断
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format, err := configFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig 解析配置内容,format取值为"yaml"或"json"
+func ParseConfig(data []byte, format string) (*Config, error) {
+	cfg := &Config{}
+	if _, err := decodeConfig(cfg, data, format); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configFormat 依据文件扩展名判断配置格式
+func configFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".json":
+		return "json", nil
+	}
+	return "", fmt.Errorf("Unsupported config file: %s!", path)
+}
+
+// decodeConfig 把配置内容解码到cfg之上,内容中未出现的字段保持原值
+// 返回内容中出现的全部字段名,嵌套字段以"."连接
+func decodeConfig(cfg *Config, data []byte, format string) ([]string, error) {
+	switch format {
+	case "json":
+	case "yaml":
+		doc, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported config format: %s!", format)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("Incorrectly formatted config: %s", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var keys []string
+	collectKeys(doc, "", &keys)
+	return keys, nil
+}
+
+// collectKeys 收集映射中全部叶子字段的名称
+func collectKeys(doc map[string]interface{}, prefix string, keys *[]string) {
+	for k, v := range doc {
+		if sub, ok := v.(map[string]interface{}); ok {
+			collectKeys(sub, prefix+k+".", keys)
+			continue
+		}
+		*keys = append(*keys, prefix+k)
+	}
+}
+
+// Check 检查配置中所有字段的有效性
+// 若存在无效字段则返回值非nil,错误信息中包含各无效字段的名称和原因
+func (cfg *Config) Check() error {
+	var errs ParamErrors
+	invalid := func(field string, format string, args ...interface{}) {
+		errs.add(field, "Invalid %s: %s!", field, fmt.Sprintf(format, args...))
+	}
+	if cfg.Target == "" {
+		invalid("target", "must not be empty")
+	}
+	if cfg.Caller == "" {
+		invalid("caller", "must not be empty")
+	}
+	if cfg.Timeout <= 0 {
+		invalid("timeout", "must be positive (got %s)", time.Duration(cfg.Timeout))
+	}
+	if cfg.LPS == 0 {
+		invalid("lps", "must be positive")
+	}
+	if cfg.Duration <= 0 {
+		invalid("duration", "must be positive (got %s)", time.Duration(cfg.Duration))
+	}
+	if cfg.Profile != "" && cfg.Profile != PROFILE_CONSTANT {
+		invalid("profile", "unsupported profile %q", cfg.Profile)
+	}
+	if cfg.Thresholds.MaxErrorRate < 0 || cfg.Thresholds.MaxErrorRate > 1 {
+		invalid("thresholds.max_error_rate", "must be in [0, 1] (got %v)", cfg.Thresholds.MaxErrorRate)
+	}
+	if cfg.Thresholds.MaxAvgElapse < 0 {
+		invalid("thresholds.max_avg_elapse", "must not be negative (got %s)",
+			time.Duration(cfg.Thresholds.MaxAvgElapse))
+	}
+	if cfg.ResultBuffer < 0 {
+		invalid("result_buffer", "must not be negative (got %d)", cfg.ResultBuffer)
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// ParamSet 检查配置并将其转换为载荷发生器参数,调用器由builder构建
+func (cfg *Config) ParamSet(builder CallerBuilder) (ParamSet, error) {
+	if err := cfg.Check(); err != nil {
+		return ParamSet{}, err
+	}
+	if builder == nil {
+		return ParamSet{}, errors.New("Invalid caller builder!")
+	}
+	caller, err := builder(cfg.Caller, cfg.Target, cfg.Options)
+	if err != nil {
+		return ParamSet{}, fmt.Errorf("Invalid caller: %s", err)
+	}
+	bufSize := cfg.ResultBuffer
+	if bufSize == 0 {
+		bufSize = DEFAULT_RESULT_BUFFER
+	}
+	ps := ParamSet{
+		Caller:     caller,
+		TimeoutNS:  time.Duration(cfg.Timeout),
+		LPS:        cfg.LPS,
+		DurationNS: time.Duration(cfg.Duration),
+		ResultCh:   make(chan *lib.CallResult, bufSize),
+		Tags:       cfg.Tags,
+		Profile:    cfg.Profile,
+	}
+	return ps, ps.Check()
+}
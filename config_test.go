@@ -0,0 +1,139 @@
+package loadgen
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+// 测试用的调用器构建函数
+func testCallerBuilder(callerType string, target string, opts lib.Options) (lib.Caller, error) {
+	return helper.NewTCPComm(target), nil
+}
+
+func TestParseConfig(t *testing.T) {
+	yamlDoc := `
+# 计算器服务压测
+target: 127.0.0.1:8000
+caller: tcp
+options:
+  keepalive: true
+  pool_size: 8
+timeout: 50ms
+lps: 1000
+duration: 10s
+profile: constant
+thresholds:
+  max_error_rate: 0.01
+  max_avg_elapse: 20ms
+tags:
+  node: "node-1"
+`
+	jsonDoc := `{
+	"target": "127.0.0.1:8000",
+	"caller": "tcp",
+	"options": {"keepalive": true, "pool_size": 8},
+	"timeout": "50ms",
+	"lps": 1000,
+	"duration": "10s",
+	"profile": "constant",
+	"thresholds": {"max_error_rate": 0.01, "max_avg_elapse": "20ms"},
+	"tags": {"node": "node-1"}
+}`
+	for format, doc := range map[string]string{"yaml": yamlDoc, "json": jsonDoc} {
+		cfg, err := ParseConfig([]byte(doc), format)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if cfg.Target != "127.0.0.1:8000" || cfg.LPS != 1000 ||
+			time.Duration(cfg.Timeout) != 50*time.Millisecond ||
+			time.Duration(cfg.Duration) != 10*time.Second ||
+			time.Duration(cfg.Thresholds.MaxAvgElapse) != 20*time.Millisecond ||
+			cfg.Options["pool_size"] != "8" || cfg.Options["keepalive"] != "true" ||
+			cfg.Tags["node"] != "node-1" {
+			t.Fatalf("%s: unexpected config: %+v", format, cfg)
+		}
+		ps, err := cfg.ParamSet(testCallerBuilder)
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		if ps.TimeoutNS != 50*time.Millisecond || cap(ps.ResultCh) != DEFAULT_RESULT_BUFFER ||
+			ps.Profile != PROFILE_CONSTANT {
+			t.Fatalf("%s: unexpected param set: %+v", format, ps)
+		}
+	}
+}
+
+func TestRegistryBuilder(t *testing.T) {
+	caller, err := RegistryBuilder("tcp", "127.0.0.1:8000", lib.Options{"pool_size": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := caller.(*helper.TCPComm); !ok {
+		t.Fatalf("Unexpected caller: %T", caller)
+	}
+	if _, err := RegistryBuilder("tcp", "tls://127.0.0.1:8000", nil); err == nil {
+		t.Fatal("Expected an error with mismatched scheme!")
+	}
+	if _, err := RegistryBuilder("tcp", "127.0.0.1:8000", lib.Options{"pool_size": "x"}); err == nil {
+		t.Fatal("Expected an error with invalid option!")
+	}
+}
+
+func TestDurationSeconds(t *testing.T) {
+	cfg, err := ParseConfig([]byte("timeout: 30\nduration: 0.5\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(cfg.Timeout) != 30*time.Second || time.Duration(cfg.Duration) != 500*time.Millisecond {
+		t.Fatalf("Unexpected durations: %s, %s", time.Duration(cfg.Timeout), time.Duration(cfg.Duration))
+	}
+}
+
+func TestThresholdsEvaluate(t *testing.T) {
+	thresholds := Thresholds{MaxErrorRate: 0.25, MaxAvgElapse: Duration(20 * time.Millisecond)}
+	results := []*lib.CallResult{
+		{Code: lib.RET_CODE_SUCCESS, Elapse: 10 * time.Millisecond},
+		{Code: lib.RET_CODE_SUCCESS, Elapse: 10 * time.Millisecond},
+		{Code: lib.RET_CODE_SUCCESS, Elapse: 10 * time.Millisecond},
+		{Code: lib.RET_CODE_ERROR_CALEE, Elapse: 30 * time.Millisecond},
+	}
+	if err := thresholds.Evaluate(results); err != nil {
+		t.Fatalf("Unexpected failure: %s", err)
+	}
+	results = append(results, &lib.CallResult{Code: lib.RET_CODE_WARNING_CALL_TIMEOUT, Elapse: time.Second})
+	errs, ok := thresholds.Evaluate(results).(ParamErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Unexpected evaluation: %v", errs)
+	}
+	if thresholds.Evaluate(nil) == nil {
+		t.Fatal("Expected a failure without results!")
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	cfg, err := ParseConfig([]byte("target: 127.0.0.1:8000\ntimeout: 0s\nlps: 10\nprofile: ramp\n"), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Check()
+	if err == nil {
+		t.Fatal("Invalid config passed the check!")
+	}
+	for _, field := range []string{"caller", "timeout", "duration", "profile"} {
+		if !strings.Contains(err.Error(), "Invalid "+field+":") {
+			t.Fatalf("Missing error for field %s: %s", field, err)
+		}
+	}
+	ps := ParamSet{Caller: helper.NewTCPComm("127.0.0.1:1"), TimeoutNS: time.Second, LPS: 1, DurationNS: time.Second,
+		ResultCh: make(chan *lib.CallResult), Profile: "ramp"}
+	if errs, ok := ps.Check().(ParamErrors); !ok || len(errs) != 1 || errs[0].Field != "Profile" {
+		t.Fatalf("Unknown profile passed the check: %v", errs)
+	}
+	if _, err := ParseConfig([]byte("target: x\nunknown: 1\n"), "yaml"); err == nil {
+		t.Fatal("Unknown field passed the parsing!")
+	}
+}
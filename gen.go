@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"sync/atomic"
 	"time"
 
@@ -28,6 +29,9 @@ type myGenerator struct {
 	callCount   int64                // 调用计数
 	status      uint32               // 状态
 	resultCh    chan *lib.CallResult // 调用结果通道
+	tags        map[string]string    // 附加到调用结果上的标签
+	logger      lib.MyLogger         // 日志记录器
+	exhausted   uint32               // 数据源是否已耗尽,耗尽后停止本次运行
 }
 
 // NewGenerator 新建一个载荷发生器
@@ -36,6 +40,11 @@ func NewGenerator(ps ParamSet) (lib.Generator, error) {
 	if err := ps.Check(); err != nil {
 		return nil, err
 	}
+	return newGenerator(ps, logger)
+}
+
+// newGenerator 依据已检查过的参数新建一个载荷发生器
+func newGenerator(ps ParamSet, logger lib.MyLogger) (lib.Generator, error) {
 	gen := &myGenerator{
 		caller:     ps.Caller,
 		timeoutNS:  ps.TimeoutNS,
@@ -43,6 +52,19 @@ func NewGenerator(ps ParamSet) (lib.Generator, error) {
 		durationNS: ps.DurationNS,
 		status:     lib.STATUS_ORIGINAL,
 		resultCh:   ps.ResultCh,
+		tags:       make(map[string]string),
+		logger:     logger,
+	}
+	for k, v := range ps.Tags {
+		gen.tags[k] = v
+	}
+	if _, ok := gen.tags[lib.TAG_CALLER]; !ok {
+		gen.tags[lib.TAG_CALLER] = fmt.Sprintf("%T", lib.UnwrapCaller(ps.Caller))
+	}
+	if _, ok := gen.tags[lib.TAG_NODE]; !ok {
+		if hostname, err := os.Hostname(); err == nil {
+			gen.tags[lib.TAG_NODE] = hostname
+		}
 	}
 	if err := gen.init(); err != nil {
 		return nil, err
@@ -67,7 +89,7 @@ func (gen *myGenerator) init() error {
 	gen.tickets = tickets
 
 	buf.WriteString(fmt.Sprintf("Done. (concurrency=%d)", gen.concurrency))
-	logger.Infoln(buf.String())
+	gen.logger.Infoln(buf.String())
 	return nil
 }
 
@@ -116,7 +138,7 @@ func (gen *myGenerator) asyncCall() {
 				} else {
 					errMsg = fmt.Sprintf("Async Call Panic! (error: %s)", p)
 				}
-				logger.Errorln(errMsg)
+				gen.logger.Errorln(errMsg)
 				//发生恐慌设置致命错误结果
 				result := &lib.CallResult{
 					ID:   -1,
@@ -130,6 +152,21 @@ func (gen *myGenerator) asyncCall() {
 		}()
 		//构建请求
 		rawReq := gen.caller.BuildReq()
+		if errors.Is(rawReq.Err, lib.ErrFeederExhausted) {
+			//数据源已耗尽,后续请求都无法构建,停止本次运行而不是逐个报告错误
+			gen.stopOnExhausted()
+			return
+		}
+		if rawReq.Err != nil {
+			//构建请求失败时不发起调用,直接以调用错误作为结果
+			gen.sendResult(&lib.CallResult{
+				ID:   rawReq.ID,
+				Req:  rawReq,
+				Code: lib.RET_CODE_ERROR_CALL,
+				Msg:  fmt.Sprintf("Build request error: %s", rawReq.Err),
+			})
+			return
+		}
 		var callStatus uint32
 		//设定超时以及后续处理
 		timer := time.AfterFunc(gen.timeoutNS, func() {
@@ -172,8 +209,21 @@ func (gen *myGenerator) asyncCall() {
 	}()
 }
 
+// stopOnExhausted 在数据源耗尽时停止本次运行,只在第一次耗尽时生效
+// 已发起的调用在停止前最多再等待一个超时时间,其结果仍会发送,超过该时间未完成的调用结果被丢弃
+func (gen *myGenerator) stopOnExhausted() {
+	if !atomic.CompareAndSwapUint32(&gen.exhausted, 0, 1) {
+		return
+	}
+	gen.logger.Infoln("Feeder exhausted, stopping load generator...")
+	if gen.cancelFunc != nil {
+		gen.cancelFunc()
+	}
+}
+
 // sendResult 用于发送处理调结果
 func (gen *myGenerator) sendResult(result *lib.CallResult) bool {
+	gen.tagResult(result)
 	if atomic.LoadUint32(&gen.status) != lib.STATUS_STARTED {
 		//未启动,打印结果忽略
 		gen.printIgnoredResult(result, "stopped load generator")
@@ -189,20 +239,41 @@ func (gen *myGenerator) sendResult(result *lib.CallResult) bool {
 	}
 }
 
+// tagResult 为调用结果附加载荷发生器的标签,不覆盖调用器已设置的同名标签
+func (gen *myGenerator) tagResult(result *lib.CallResult) {
+	for k, v := range gen.tags {
+		if _, ok := result.Tags[k]; !ok {
+			result.SetTag(k, v)
+		}
+	}
+}
+
 // printIgnoredResult 打印忽略的结果
 func (gen *myGenerator) printIgnoredResult(result *lib.CallResult, cause string) {
 	resultMsg := fmt.Sprintf("ID=%d, Code=%d, Msg=%s, Elapse=%v", result.ID, result.Code, result.Msg, result.Elapse)
-	logger.Warnf("Ignored result: %s. (cause: %s)", resultMsg, cause)
+	gen.logger.Warnf("Ignored result: %s. (cause: %s)", resultMsg, cause)
 }
 
 // prepareToStop 用于停止载荷发生做准备
 func (gen *myGenerator) prepareToStop(ctxError error) {
-	logger.Infof("Prepare to stop load generator (cuase: %s)...", ctxError)
+	gen.logger.Infof("Prepare to stop load generator (cuase: %s)...", ctxError)
+	if atomic.LoadUint32(&gen.exhausted) == 1 {
+		gen.waitInFlight()
+	}
 	atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_STARTED, lib.STATUS_STOPPING)
 	close(gen.resultCh)
 	atomic.StoreUint32(&gen.status, lib.STATUS_STOPPED)
 }
 
+// waitInFlight 等待已发起的调用归还票池,最多等待一个超时时间
+// 未在超时时间内完成的调用会由定时器发送超时结果
+func (gen *myGenerator) waitInFlight() {
+	deadline := time.Now().Add(gen.timeoutNS)
+	for gen.tickets.Remainder() < gen.tickets.Total() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
 // genLoad 产生载荷并向承受方发送
 func (gen *myGenerator) genLoad(throttle <-chan time.Time) {
 	for {
@@ -229,7 +300,7 @@ func (gen *myGenerator) genLoad(throttle <-chan time.Time) {
 
 // Start 启动载荷发生器
 func (gen *myGenerator) Start() bool {
-	logger.Infoln("Starting load generator...")
+	gen.logger.Infoln("Starting load generator...")
 	//检查是否具备可启的状态,顺便设置状态为正在启动
 	if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_ORIGINAL, lib.STATUS_STARTING) {
 		if !atomic.CompareAndSwapUint32(&gen.status, lib.STATUS_STOPPED, lib.STATUS_STARTING) {
@@ -241,7 +312,7 @@ func (gen *myGenerator) Start() bool {
 	var throttle <-chan time.Time
 	if gen.lps > 0 {
 		interval := time.Duration(1e9 / gen.lps)
-		logger.Infof("Setting throttle (%v)...", interval)
+		gen.logger.Infof("Setting throttle (%v)...", interval)
 		throttle = time.Tick(interval)
 	}
 
@@ -251,14 +322,20 @@ func (gen *myGenerator) Start() bool {
 	//初始化调用计数
 	gen.callCount = 0
 
+	//调用器使用的已注册数据源从头读取,不影响其他载荷发生器使用的数据源
+	if user, ok := lib.UnwrapCaller(gen.caller).(lib.FeederUser); ok {
+		lib.ResetFeeders(user.Feeders()...)
+	}
+	atomic.StoreUint32(&gen.exhausted, 0)
+
 	//设置状态为已启动
 	atomic.StoreUint32(&gen.status, lib.STATUS_STARTED)
 
 	go func() {
 		//生成并发送载荷
-		logger.Infoln("Generating loads...")
+		gen.logger.Infoln("Generating loads...")
 		gen.genLoad(throttle)
-		logger.Infof("Stopped. (call count: %d)", gen.callCount)
+		gen.logger.Infof("Stopped. (call count: %d)", gen.callCount)
 	}()
 	return false
 }
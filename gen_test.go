@@ -1,6 +1,9 @@
 package loadgen
 
 import (
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -118,3 +121,146 @@ func TestStop(t *testing.T) {
 	tps := float64(successCount) / float64(timeoutNS/1e9)
 	t.Logf("Loads per second: %d; Treatments per second: %f.\n", ps.LPS, tps)
 }
+
+// tagCaller 表示依据模式模拟成功、构建失败、超时和恐慌,并在成功结果上设置标签的调用器
+type tagCaller struct {
+	mode string
+}
+
+func (c *tagCaller) BuildReq() loadgenlib.RawReq {
+	if c.mode == "build" {
+		return loadgenlib.RawReq{ID: 7, Err: errors.New("bad template")}
+	}
+	return loadgenlib.RawReq{ID: 7, Req: []byte(c.mode)}
+}
+
+func (c *tagCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	switch string(req) {
+	case "timeout":
+		time.Sleep(2 * timeoutNS)
+	case "panic":
+		panic("boom")
+	}
+	return req, nil
+}
+
+func (c *tagCaller) CheckResp(rawReq loadgenlib.RawReq, rawResp loadgenlib.RawResp) *loadgenlib.CallResult {
+	result := &loadgenlib.CallResult{ID: rawReq.ID, Code: loadgenlib.RET_CODE_SUCCESS}
+	result.SetTag(loadgenlib.TAG_PHASE, "caller")
+	return result
+}
+
+func TestGeneratorTags(t *testing.T) {
+	caller := &tagCaller{}
+	ps := ParamSet{
+		Caller:     caller,
+		TimeoutNS:  20 * time.Millisecond,
+		LPS:        1,
+		DurationNS: time.Second,
+		ResultCh:   make(chan *loadgenlib.CallResult, 10),
+		Tags:       map[string]string{loadgenlib.TAG_PHASE: "steady", "env": "test"},
+	}
+	gen, err := newGenerator(ps, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := gen.(*myGenerator)
+	atomic.StoreUint32(&g.status, loadgenlib.STATUS_STARTED)
+	for _, c := range []struct {
+		mode  string
+		code  loadgenlib.RetCode
+		phase string // 调用器设置的标签不被载荷发生器覆盖
+	}{
+		{"ok", loadgenlib.RET_CODE_SUCCESS, "caller"},
+		{"build", loadgenlib.RET_CODE_ERROR_CALL, "steady"},
+		{"timeout", loadgenlib.RET_CODE_WARNING_CALL_TIMEOUT, "steady"},
+		{"panic", loadgenlib.RET_CODE_FATAL_CALL, "steady"},
+	} {
+		caller.mode = c.mode
+		g.asyncCall()
+		result := <-ps.ResultCh
+		if result.Code != c.code || (c.mode != "panic" && result.ID != 7) {
+			t.Fatalf("%s: unexpected result: %v", c.mode, result)
+		}
+		if result.Tag(loadgenlib.TAG_PHASE) != c.phase || result.Tag("env") != "test" ||
+			result.Tag(loadgenlib.TAG_CALLER) != "*loadgen.tagCaller" || result.Tag(loadgenlib.TAG_NODE) == "" {
+			t.Fatalf("%s: unexpected tags: %v", c.mode, result.Tags)
+		}
+	}
+}
+
+// feedCaller 从已注册的数据源读取记录构建请求,每次调用耗时30毫秒
+type feedCaller struct{}
+
+func (feedCaller) Feeders() []string {
+	return []string{"gen.test"}
+}
+
+func (feedCaller) BuildReq() loadgenlib.RawReq {
+	feeder, _ := loadgenlib.LookupFeeder("gen.test")
+	record, err := feeder.Next()
+	if err != nil {
+		return loadgenlib.RawReq{ID: 1, Err: fmt.Errorf("render: %w", err)}
+	}
+	return loadgenlib.RawReq{ID: 1, Req: []byte(record["id"])}
+}
+
+func (feedCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	time.Sleep(30 * time.Millisecond)
+	return req, nil
+}
+
+func (feedCaller) CheckResp(rawReq loadgenlib.RawReq, rawResp loadgenlib.RawResp) *loadgenlib.CallResult {
+	return &loadgenlib.CallResult{ID: rawReq.ID, Code: loadgenlib.RET_CODE_SUCCESS, Msg: string(rawResp.Resp)}
+}
+
+func TestFeederExhausted(t *testing.T) {
+	for _, name := range []string{"gen.test", "gen.other"} {
+		feeder, ok := loadgenlib.LookupFeeder(name)
+		if !ok {
+			var err error
+			feeder, err = loadgenlib.NewFeeder([]loadgenlib.Record{{"id": "a"}, {"id": "b"}, {"id": "c"}}, loadgenlib.FEED_SEQUENTIAL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loadgenlib.RegisterFeeder(name, feeder)
+		}
+		feeder.Reset()
+	}
+	other, _ := loadgenlib.LookupFeeder("gen.other")
+	other.Next()
+	// 每次启动都从头读取调用器使用的数据源,耗尽后在持续时间结束前停止且不报告错误,
+	// 耗尽时仍在进行的调用的结果不被丢弃
+	for run := 0; run < 2; run++ {
+		ps := ParamSet{
+			Caller:     feedCaller{},
+			TimeoutNS:  200 * time.Millisecond,
+			LPS:        100,
+			DurationNS: 10 * time.Second,
+			ResultCh:   make(chan *loadgenlib.CallResult, 10),
+		}
+		gen, err := newGenerator(ps, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		begin := time.Now()
+		gen.Start()
+		var msgs []string
+		for result := range ps.ResultCh {
+			if result.Code != loadgenlib.RET_CODE_SUCCESS {
+				t.Fatalf("Run %d: unexpected result: %v", run, result)
+			}
+			msgs = append(msgs, result.Msg)
+		}
+		if len(msgs) != 3 {
+			t.Fatalf("Run %d: unexpected results: %v", run, msgs)
+		}
+		if elapsed := time.Since(begin); elapsed > 5*time.Second {
+			t.Fatalf("Run %d: generator did not stop on exhaustion (elapsed=%v)", run, elapsed)
+		}
+	}
+	// 其他调用器使用的数据源不被重置
+	if n := other.Remaining(); n != 2 {
+		t.Fatalf("Unrelated feeder was reset! (remaining=%d)", n)
+	}
+}
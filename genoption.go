@@ -0,0 +1,130 @@
+package loadgen
+
+import (
+	"time"
+
+	"loadgen/lib"
+)
+
+// 载荷发生器参数的默认值
+const (
+	DEFAULT_TIMEOUT  = time.Second      // 默认响应超时时间
+	DEFAULT_LPS      = uint32(100)      // 默认每秒载荷数
+	DEFAULT_DURATION = 10 * time.Second // 默认负载持续时间
+)
+
+// genOptions 表示构建载荷发生器时可选的配置项
+type genOptions struct {
+	ps          ParamSet         // 载荷发生器参数
+	logger      lib.MyLogger     // 日志记录器
+	middlewares []lib.Middleware // 包装调用器的中间件
+}
+
+// Option 表示载荷发生器的配置项
+type Option func(opts *genOptions)
+
+// WithCaller 设置调用器
+func WithCaller(caller lib.Caller) Option {
+	return func(opts *genOptions) {
+		opts.ps.Caller = caller
+	}
+}
+
+// WithRate 设置每秒载荷数
+func WithRate(lps uint32) Option {
+	return func(opts *genOptions) {
+		opts.ps.LPS = lps
+	}
+}
+
+// WithTimeout 设置响应超时时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *genOptions) {
+		opts.ps.TimeoutNS = timeout
+	}
+}
+
+// WithDuration 设置负载持续时间
+func WithDuration(duration time.Duration) Option {
+	return func(opts *genOptions) {
+		opts.ps.DurationNS = duration
+	}
+}
+
+// WithResultSink 设置调用结果通道,载荷发生器停止时会关闭该通道
+func WithResultSink(resultCh chan *lib.CallResult) Option {
+	return func(opts *genOptions) {
+		opts.ps.ResultCh = resultCh
+	}
+}
+
+// WithLogger 设置日志记录器
+func WithLogger(logger lib.MyLogger) Option {
+	return func(opts *genOptions) {
+		opts.logger = logger
+	}
+}
+
+// WithTags 设置附加到每个调用结果上的标签,可多次调用
+func WithTags(tags map[string]string) Option {
+	return func(opts *genOptions) {
+		if opts.ps.Tags == nil {
+			opts.ps.Tags = make(map[string]string)
+		}
+		for k, v := range tags {
+			opts.ps.Tags[k] = v
+		}
+	}
+}
+
+// WithMiddleware 用中间件包装调用器,可多次调用,先设置的中间件位于外层
+func WithMiddleware(middlewares ...lib.Middleware) Option {
+	return func(opts *genOptions) {
+		opts.middlewares = append(opts.middlewares, middlewares...)
+	}
+}
+
+// WithParamSet 以已有的参数集合为基础进行配置
+func WithParamSet(ps ParamSet) Option {
+	return func(opts *genOptions) {
+		opts.ps = ps
+	}
+}
+
+// New 依据配置项新建一个载荷发生器
+// 调用器和调用结果通道必须设置,其余参数未设置时采用默认值
+// 参数无效时返回的错误类型为ParamErrors,其中包含全部无效的参数
+func New(options ...Option) (lib.Generator, error) {
+	opts := &genOptions{
+		ps: ParamSet{
+			TimeoutNS:  DEFAULT_TIMEOUT,
+			LPS:        DEFAULT_LPS,
+			DurationNS: DEFAULT_DURATION,
+		},
+		logger: logger,
+	}
+	for _, option := range options {
+		option(opts)
+	}
+	if opts.ps.Caller != nil && len(opts.middlewares) > 0 {
+		opts.ps.Caller = lib.Chain(opts.ps.Caller, opts.middlewares...)
+	}
+	var errs ParamErrors
+	checkLogger := opts.logger
+	if checkLogger == nil {
+		errs.add("Logger", "Invalid logger!")
+		checkLogger = logger
+	}
+	if err := opts.ps.check(checkLogger); err != nil {
+		psErrs, ok := err.(ParamErrors)
+		if !ok {
+			return nil, err
+		}
+		errs = append(psErrs, errs...)
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	opts.logger.Infoln("New a load generator...")
+	return newGenerator(opts.ps, opts.logger)
+}
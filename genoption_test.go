@@ -0,0 +1,84 @@
+package loadgen
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"loadgen/lib"
+	helper "loadgen/testhelper"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	_, err := New(WithRate(0), WithTimeout(-time.Second))
+	errs, ok := err.(ParamErrors)
+	if !ok {
+		t.Fatalf("Unexpected error type: %T", err)
+	}
+	expected := []string{"Caller", "TimeoutNS", "LPS", "ResultCh"}
+	if !reflect.DeepEqual(errs.Fields(), expected) {
+		t.Fatalf("Unexpected invalid fields: %v (expected: %v)", errs.Fields(), expected)
+	}
+
+	gen, err := New(
+		WithCaller(helper.NewTCPComm("127.0.0.1:8000")),
+		WithResultSink(make(chan *lib.CallResult, 10)),
+		WithLogger(lib.DLogger()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gen.Status() != lib.STATUS_ORIGINAL {
+		t.Fatalf("Unexpected status: %d", gen.Status())
+	}
+}
+
+// recordLogger 表示记录Infoln输出的日志记录器
+type recordLogger struct {
+	lib.MyLogger
+	lines []string
+}
+
+func (l *recordLogger) Infoln(v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(v...))
+}
+
+func TestNewWithLogger(t *testing.T) {
+	rl := &recordLogger{MyLogger: lib.DLogger()}
+	if _, err := New(WithLogger(rl)); err == nil {
+		t.Fatal("Expected an error without caller!")
+	}
+	if len(rl.lines) != 1 || !strings.Contains(rl.lines[0], "NOT passed!") {
+		t.Fatalf("Parameter check was not logged with the given logger: %q", rl.lines)
+	}
+	_, err := New(WithLogger(nil), WithCaller(helper.NewTCPComm("127.0.0.1:8000")),
+		WithResultSink(make(chan *lib.CallResult, 10)))
+	if errs, ok := err.(ParamErrors); !ok || !reflect.DeepEqual(errs.Fields(), []string{"Logger"}) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestNewWithMiddleware(t *testing.T) {
+	caller := helper.NewTCPComm("127.0.0.1:8000")
+	var wrapped int
+	gen, err := New(
+		WithCaller(caller),
+		WithResultSink(make(chan *lib.CallResult, 10)),
+		WithMiddleware(func(next lib.Caller) lib.Caller {
+			wrapped++
+			return next
+		}, lib.TracingMiddleware(nil)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapped != 1 {
+		t.Fatalf("Unexpected number of wrapping: %d", wrapped)
+	}
+	// 调用器标签仍使用被包装的调用器类型
+	if tag := gen.(*myGenerator).tags[lib.TAG_CALLER]; tag != "*testhelper.TCPComm" {
+		t.Fatalf("Unexpected caller tag: %s", tag)
+	}
+}
@@ -0,0 +1,28 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 支持的流式网络类型
+var streamNetworks = []string{"tcp", "tcp4", "tcp6", "unix", "unixpacket"}
+
+// SplitNetworkAddr 把形如"unix:///tmp/app.sock"、"tcp://host:port"或"host:port"的地址
+// 拆分为网络类型和地址,未指定网络类型时默认为tcp
+func SplitNetworkAddr(addr string) (string, string, error) {
+	i := strings.Index(addr, "://")
+	if i < 0 {
+		return "tcp", addr, nil
+	}
+	network, address := addr[:i], addr[i+3:]
+	for _, n := range streamNetworks {
+		if n == network {
+			if address == "" {
+				return "", "", fmt.Errorf("Invalid address: %s!", addr)
+			}
+			return network, address, nil
+		}
+	}
+	return "", "", fmt.Errorf("Unsupported network: %s!", network)
+}
@@ -9,6 +9,7 @@ import (
 type RawReq struct {
 	ID  int64
 	Req []byte
+	Err error // 构建请求时发生的错误(如模板渲染失败),非nil时不会发起调用
 }
 
 func (req RawReq) String() string {
@@ -32,12 +33,13 @@ type RetCode int
 
 // 保留1 ~ 100 给载荷承受方使用
 const (
-	RET_CODE_SUCCESS              RetCode = 0    // 成功
-	RET_CODE_WARNING_CALL_TIMEOUT         = 1001 // 调用超时警告
-	RET_CODE_ERROR_CALL                   = 2001 // 调用错误
-	RET_CODE_ERROR_RESPONSE               = 2002 // 响应内容错误
-	RET_CODE_ERROR_CALEE                  = 2003 // 被调用方(被测软件)的内部错误
-	RET_CODE_FATAL_CALL                   = 3001 // 调用过程发生了致使错误!
+	RET_CODE_SUCCESS               RetCode = 0    // 成功
+	RET_CODE_WARNING_CALL_TIMEOUT          = 1001 // 调用超时警告
+	RET_CODE_WARNING_DATAGRAM_LOST         = 1002 // 数据报丢失警告
+	RET_CODE_ERROR_CALL                    = 2001 // 调用错误
+	RET_CODE_ERROR_RESPONSE                = 2002 // 响应内容错误
+	RET_CODE_ERROR_CALEE                   = 2003 // 被调用方(被测软件)的内部错误
+	RET_CODE_FATAL_CALL                    = 3001 // 调用过程发生了致使错误!
 )
 
 // GetRetCodePlain 会依据结果返回相应的文字解释
@@ -48,6 +50,8 @@ func GetRetCodePlain(code RetCode) string {
 		codePlain = "Success"
 	case RET_CODE_WARNING_CALL_TIMEOUT:
 		codePlain = "Call Timeout Warning"
+	case RET_CODE_WARNING_DATAGRAM_LOST:
+		codePlain = "Datagram Lost Warning"
 	case RET_CODE_ERROR_CALL:
 		codePlain = "Call Error"
 	case RET_CODE_ERROR_RESPONSE:
@@ -64,17 +68,58 @@ func GetRetCodePlain(code RetCode) string {
 
 // CallResult 代表调用结果的结构
 type CallResult struct {
-	ID     int64         // ID
-	Req    RawReq        // 原生请求
-	Resp   RawResp       // 原生响应
-	Code   RetCode       // 响应代码
-	Msg    string        // 结果成因的简述
-	Elapse time.Duration // 耗时
+	ID     int64             // ID
+	Req    RawReq            // 原生请求
+	Resp   RawResp           // 原生响应
+	Code   RetCode           // 响应代码
+	Msg    string            // 结果成因的简述
+	Elapse time.Duration     // 耗时
+	Tags   map[string]string // 标签,用于分组统计
 }
 
 func (r CallResult) String() string {
-	return fmt.Sprintf("ID:%d, Req:%v, Resp:%v, Code:%d, Msg:%s, Elapse:%v",
-		r.ID, r.Req, r.Resp, r.Code, r.Msg, r.Elapse)
+	return fmt.Sprintf("ID:%d, Req:%v, Resp:%v, Code:%d, Msg:%s, Elapse:%v, Tags:%v",
+		r.ID, r.Req, r.Resp, r.Code, r.Msg, r.Elapse, r.Tags)
+}
+
+// 声明内置的调用结果标签名
+const (
+	// TAG_CALLER 代表调用器名称
+	TAG_CALLER = "caller"
+	// TAG_STEP 代表会话中的步骤名称
+	TAG_STEP = "step"
+	// TAG_TRACE 代表追踪中间件生成的追踪ID
+	TAG_TRACE = "trace"
+	// TAG_NODE 代表运行载荷发生器的节点,未设置时载荷发生器以主机名补全
+	TAG_NODE = "node"
+	// TAG_PHASE 代表负载测试的阶段(如预热、施压),由使用方通过ParamSet.Tags设置
+	TAG_PHASE = "phase"
+)
+
+// SetTag 设置一个标签,已存在的同名标签将被覆盖
+func (r *CallResult) SetTag(key, value string) {
+	if r.Tags == nil {
+		r.Tags = make(map[string]string)
+	}
+	r.Tags[key] = value
+}
+
+// Tag 获取指定标签的值,不存在时返回空字符串
+func (r *CallResult) Tag(key string) string {
+	return r.Tags[key]
+}
+
+// GroupByTag 按指定标签对调用结果分组,未设置该标签的结果归入空字符串分组
+func GroupByTag(results []*CallResult, key string) map[string][]*CallResult {
+	groups := make(map[string][]*CallResult)
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		v := r.Tag(key)
+		groups[v] = append(groups[v], r)
+	}
+	return groups
 }
 
 // 声明代表载荷发生器状态的常量
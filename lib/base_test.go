@@ -10,9 +10,45 @@ func TestGetRetCodePlain(t *testing.T) {
 	var retCode RetCode = 9999
 	fmt.Printf("RET_CODE_SUCCESS : %v\n", GetRetCodePlain(RET_CODE_SUCCESS))
 	fmt.Printf("RET_CODE_WARNING_CALL_TIMEOUT: %v\n", GetRetCodePlain(RET_CODE_WARNING_CALL_TIMEOUT))
+	fmt.Printf("RET_CODE_WARNING_DATAGRAM_LOST: %v\n", GetRetCodePlain(RET_CODE_WARNING_DATAGRAM_LOST))
 	fmt.Printf("RET_CODE_ERROR_CALL : %v\n", GetRetCodePlain(RET_CODE_ERROR_CALL))
 	fmt.Printf("RET_CODE_ERROR_RESPONSE : %v\n", GetRetCodePlain(RET_CODE_ERROR_RESPONSE))
 	fmt.Printf("RET_CODE_ERROR_CALEE : %v\n", GetRetCodePlain(RET_CODE_ERROR_CALEE))
 	fmt.Printf("RET_CODE_FATAL_CALL : %v\n", GetRetCodePlain(RET_CODE_FATAL_CALL))
 	fmt.Printf("UNKONW ERROR CODE: %v\n", GetRetCodePlain(retCode))
 }
+
+// 测试按标签分组
+func TestGroupByTag(t *testing.T) {
+	var results []*CallResult
+	for i, endpoint := range []string{"/a", "/b", "/a", ""} {
+		r := &CallResult{ID: int64(i)}
+		if endpoint != "" {
+			r.SetTag("endpoint", endpoint)
+		}
+		results = append(results, r)
+	}
+	groups := GroupByTag(results, "endpoint")
+	if len(groups["/a"]) != 2 || len(groups["/b"]) != 1 || len(groups[""]) != 1 {
+		t.Fatalf("Unexpected groups: %v", groups)
+	}
+}
+
+// 测试地址拆分
+func TestSplitNetworkAddr(t *testing.T) {
+	cases := map[string][2]string{
+		"127.0.0.1:8000":             {"tcp", "127.0.0.1:8000"},
+		"tcp://127.0.0.1:8000":       {"tcp", "127.0.0.1:8000"},
+		"unix:///tmp/app.sock":       {"unix", "/tmp/app.sock"},
+		"unixpacket:///tmp/app.sock": {"unixpacket", "/tmp/app.sock"},
+	}
+	for addr, expected := range cases {
+		network, address, err := SplitNetworkAddr(addr)
+		if err != nil || network != expected[0] || address != expected[1] {
+			t.Fatalf("Unexpected split of %s: %s %s %v", addr, network, address, err)
+		}
+	}
+	if _, _, err := SplitNetworkAddr("udp://127.0.0.1:8000"); err == nil {
+		t.Fatal("Unsupported network passed the split!")
+	}
+}
@@ -11,3 +11,13 @@ type Caller interface {
 	// 检查响应
 	CheckResp(rawReq RawReq, rawResp RawResp) *CallResult
 }
+
+// RequestEditor 表示允许改写实际发出的载荷和头部的调用器
+// 改写请求的中间件(GzipMiddleware、SigningMiddleware和TracingMiddleware)只能用于实现了该接口的调用器;
+// 在原生请求中序列化协议信封(如HTTP的方法、地址和请求头)的调用器须由该接口解码和重新编码信封,
+// 原生请求即为载荷的调用器以原生请求调用edit即可
+type RequestEditor interface {
+	// EditRequest 以edit改写原生请求中的载荷和头部,返回重新编码后的原生请求
+	// 传给edit的header可直接修改,为nil表示协议不支持头部
+	EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error)
+}
@@ -0,0 +1,186 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolClosed 表示连接池已关闭
+var ErrPoolClosed = errors.New("The connection pool is closed!")
+
+// PoolConfig 表示连接池的配置
+type PoolConfig struct {
+	Size        uint32        // 连接总数上限(含使用中和空闲的连接)
+	IdleTimeout time.Duration // 空闲超过该时间的连接不再复用,0表示不限制
+	MaxLifetime time.Duration // 建立超过该时间的连接不再复用,0表示不限制
+	HealthCheck bool          // 复用前是否检查连接是否已被对端关闭
+}
+
+// DialFunc 表示在超时时间内建立一个连接的函数
+type DialFunc func(timeoutNS time.Duration) (net.Conn, error)
+
+// PooledConn 表示连接池中的连接
+type PooledConn struct {
+	net.Conn
+	created  time.Time // 建立时间
+	lastUsed time.Time // 最近一次归还的时间
+}
+
+// ConnPool 代表连接池的接口
+type ConnPool interface {
+	// 在超时时间内获取一个连接,优先复用空闲的连接
+	Get(timeoutNS time.Duration) (*PooledConn, error)
+	// 归还连接,broken为true时连接将被关闭而不再复用
+	Put(conn *PooledConn, broken bool)
+	// 关闭连接池及其中的空闲连接
+	Close()
+	// 空闲的连接数
+	Idle() int
+	// 累计建立的连接数
+	Opened() int64
+}
+
+// myConnPool 表示连接池的实现
+type myConnPool struct {
+	cfg    PoolConfig
+	dial   DialFunc
+	slots  chan struct{} // 连接名额
+	mu     sync.Mutex
+	idle   []*PooledConn // 空闲的连接,后进先出
+	closed bool
+	opened int64
+}
+
+// NewConnPool 新建一个连接池
+func NewConnPool(dial DialFunc, cfg PoolConfig) (ConnPool, error) {
+	if dial == nil {
+		return nil, errors.New("Invalid dial function!")
+	}
+	if cfg.Size == 0 {
+		return nil, fmt.Errorf("Invalid pool size! (size=%d)", cfg.Size)
+	}
+	pool := &myConnPool{
+		cfg:   cfg,
+		dial:  dial,
+		slots: make(chan struct{}, cfg.Size),
+	}
+	for i := uint32(0); i < cfg.Size; i++ {
+		pool.slots <- struct{}{}
+	}
+	return pool, nil
+}
+
+// Get 在超时时间内获取一个连接
+func (pool *myConnPool) Get(timeoutNS time.Duration) (*PooledConn, error) {
+	pool.mu.Lock()
+	closed := pool.closed
+	pool.mu.Unlock()
+	if closed {
+		return nil, ErrPoolClosed
+	}
+	deadline := time.Now().Add(timeoutNS)
+	timer := time.NewTimer(timeoutNS)
+	defer timer.Stop()
+	select {
+	case <-pool.slots:
+	case <-timer.C:
+		return nil, fmt.Errorf("Timeout waiting for a pooled connection! (size=%d)", pool.cfg.Size)
+	}
+	for {
+		conn := pool.popIdle()
+		if conn == nil {
+			break
+		}
+		if pool.reusable(conn) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+	c, err := pool.dial(time.Until(deadline))
+	if err != nil {
+		pool.slots <- struct{}{}
+		return nil, err
+	}
+	atomic.AddInt64(&pool.opened, 1)
+	return &PooledConn{Conn: c, created: time.Now()}, nil
+}
+
+// popIdle 取出最近归还的空闲连接
+func (pool *myConnPool) popIdle() *PooledConn {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	n := len(pool.idle)
+	if n == 0 {
+		return nil
+	}
+	conn := pool.idle[n-1]
+	pool.idle = pool.idle[:n-1]
+	return conn
+}
+
+// reusable 判断空闲连接是否可以复用
+func (pool *myConnPool) reusable(conn *PooledConn) bool {
+	now := time.Now()
+	if pool.cfg.IdleTimeout > 0 && now.Sub(conn.lastUsed) > pool.cfg.IdleTimeout {
+		return false
+	}
+	if pool.cfg.MaxLifetime > 0 && now.Sub(conn.created) > pool.cfg.MaxLifetime {
+		return false
+	}
+	if pool.cfg.HealthCheck {
+		// 空闲连接上不应有可读的数据,读到数据或EOF说明连接已不可用
+		var one [1]byte
+		conn.SetReadDeadline(now)
+		_, err := conn.Read(one[:])
+		conn.SetReadDeadline(time.Time{})
+		ne, ok := err.(net.Error)
+		if !ok || !ne.Timeout() {
+			return false
+		}
+	}
+	return true
+}
+
+// Put 归还连接
+func (pool *myConnPool) Put(conn *PooledConn, broken bool) {
+	if conn == nil {
+		return
+	}
+	pool.mu.Lock()
+	if broken || pool.closed {
+		pool.mu.Unlock()
+		conn.Close()
+	} else {
+		conn.lastUsed = time.Now()
+		pool.idle = append(pool.idle, conn)
+		pool.mu.Unlock()
+	}
+	pool.slots <- struct{}{}
+}
+
+// Close 关闭连接池及其中的空闲连接
+func (pool *myConnPool) Close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.closed = true
+	for _, conn := range pool.idle {
+		conn.Close()
+	}
+	pool.idle = nil
+}
+
+// Idle 空闲的连接数
+func (pool *myConnPool) Idle() int {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return len(pool.idle)
+}
+
+// Opened 累计建立的连接数
+func (pool *myConnPool) Opened() int64 {
+	return atomic.LoadInt64(&pool.opened)
+}
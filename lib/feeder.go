@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Record 表示数据源中的一条记录,键为CSV的列名或JSON对象的字段名
+type Record map[string]string
+
+// FeedStrategy 表示数据源读取记录的策略
+type FeedStrategy string
+
+// 数据源读取记录的策略
+const (
+	FEED_SEQUENTIAL FeedStrategy = "sequential" // 按顺序读取,读完后耗尽
+	FEED_CIRCULAR   FeedStrategy = "circular"   // 按顺序循环读取
+	FEED_RANDOM     FeedStrategy = "random"     // 随机读取,可能重复
+	FEED_UNIQUE     FeedStrategy = "unique"     // 以随机顺序读取,每条记录只读取一次,读完后耗尽
+)
+
+// ErrFeederExhausted 表示数据源的记录已读完
+// 构建请求时RawReq.Err包含该错误的,载荷发生器会停止本次运行
+var ErrFeederExhausted = errors.New("Feeder exhausted!")
+
+// Feeder 表示为构建请求提供记录的数据源,可被多个goroutine并发使用
+type Feeder struct {
+	strategy FeedStrategy
+	records  []Record
+	mu       sync.Mutex
+	next     int   // 下一次读取的位置
+	perm     []int // FEED_UNIQUE策略的读取顺序
+}
+
+// NewFeeder 依据记录和读取策略新建一个数据源,策略为空时使用FEED_CIRCULAR
+func NewFeeder(records []Record, strategy FeedStrategy) (*Feeder, error) {
+	if len(records) == 0 {
+		return nil, errors.New("Invalid feeder records: empty!")
+	}
+	switch strategy {
+	case "":
+		strategy = FEED_CIRCULAR
+	case FEED_SEQUENTIAL, FEED_CIRCULAR, FEED_RANDOM, FEED_UNIQUE:
+	default:
+		return nil, fmt.Errorf("Invalid feed strategy: %s!", strategy)
+	}
+	f := &Feeder{strategy: strategy, records: records}
+	f.Reset()
+	return f, nil
+}
+
+// LoadFeeder 从CSV或JSONL文件加载数据源,格式依据文件扩展名(.csv、.jsonl或.ndjson)判断
+func LoadFeeder(path string, strategy FeedStrategy) (*Feeder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []Record
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		records, err = ReadCSVRecords(file)
+	case ".jsonl", ".ndjson":
+		records, err = ReadJSONLRecords(file)
+	default:
+		return nil, fmt.Errorf("Unsupported feeder file format: %s!", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid feeder file %s: %s", path, err)
+	}
+	return NewFeeder(records, strategy)
+}
+
+// ReadCSVRecords 读取CSV格式的记录,第一行为列名
+func ReadCSVRecords(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("Missing CSV header!")
+		}
+		return nil, err
+	}
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := make(Record, len(header))
+		for i, name := range header {
+			record[name] = row[i]
+		}
+		records = append(records, record)
+	}
+}
+
+// ReadJSONLRecords 读取JSONL格式的记录,每行一个JSON对象,空行被忽略
+// 字符串字段保持原样,其他类型的字段以JSON文本表示
+func ReadJSONLRecords(r io.Reader) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var records []Record
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		record := make(Record, len(fields))
+		for k, raw := range fields {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				record[k] = s
+			} else {
+				record[k] = string(raw)
+			}
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Strategy 返回读取策略
+func (f *Feeder) Strategy() FeedStrategy {
+	return f.strategy
+}
+
+// Len 返回记录数
+func (f *Feeder) Len() int {
+	return len(f.records)
+}
+
+// Next 读取下一条记录,FEED_SEQUENTIAL和FEED_UNIQUE策略在记录读完后返回ErrFeederExhausted
+// 返回的记录被所有读取方共享,不应修改
+func (f *Feeder) Next() (Record, error) {
+	if f.strategy == FEED_RANDOM {
+		return f.records[rand.Intn(len(f.records))], nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := f.next
+	switch f.strategy {
+	case FEED_CIRCULAR:
+		f.next = (i + 1) % len(f.records)
+		return f.records[i], nil
+	case FEED_UNIQUE:
+		if i >= len(f.perm) {
+			return nil, ErrFeederExhausted
+		}
+		f.next++
+		return f.records[f.perm[i]], nil
+	default:
+		if i >= len(f.records) {
+			return nil, ErrFeederExhausted
+		}
+		f.next++
+		return f.records[i], nil
+	}
+}
+
+// Remaining 返回耗尽前还可读取的记录数,FEED_CIRCULAR和FEED_RANDOM策略不会耗尽,返回-1
+func (f *Feeder) Remaining() int {
+	if f.strategy == FEED_CIRCULAR || f.strategy == FEED_RANDOM {
+		return -1
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.records) - f.next
+}
+
+// Reset 从头开始新一轮读取,FEED_UNIQUE策略会重新打乱读取顺序
+func (f *Feeder) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next = 0
+	if f.strategy == FEED_UNIQUE {
+		f.perm = rand.Perm(len(f.records))
+	}
+}
+
+// 已注册的数据源,键为名称
+var (
+	feedersMu sync.RWMutex
+	feeders   = make(map[string]*Feeder)
+)
+
+// RegisterFeeder 以名称注册数据源,注册后可在请求模板中以feed函数读取
+// 名称重复注册或数据源为nil时会引发恐慌
+func RegisterFeeder(name string, feeder *Feeder) {
+	if feeder == nil {
+		panic("lib: RegisterFeeder feeder is nil")
+	}
+	feedersMu.Lock()
+	defer feedersMu.Unlock()
+	if _, dup := feeders[name]; dup {
+		panic("lib: RegisterFeeder called twice for name " + name)
+	}
+	feeders[name] = feeder
+}
+
+// LookupFeeder 查找名称对应的数据源
+func LookupFeeder(name string) (*Feeder, bool) {
+	feedersMu.RLock()
+	defer feedersMu.RUnlock()
+	feeder, ok := feeders[name]
+	return feeder, ok
+}
+
+// FeederUser 表示使用已注册数据源的调用器,载荷发生器启动时只重置其使用的数据源
+type FeederUser interface {
+	Feeders() []string // 使用的已注册数据源的名称
+}
+
+// ResetFeeders 使指定名称的已注册数据源从头开始新一轮读取,未注册的名称被忽略
+func ResetFeeders(names ...string) {
+	for _, name := range names {
+		if feeder, ok := LookupFeeder(name); ok {
+			feeder.Reset()
+		}
+	}
+}
+
+// Feed 从已注册的数据源读取下一条记录
+func Feed(name string) (Record, error) {
+	feeder, ok := LookupFeeder(name)
+	if !ok {
+		return nil, fmt.Errorf("Unknown feeder: %s!", name)
+	}
+	return feeder.Next()
+}
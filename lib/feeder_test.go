@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// writeFeederFile 在临时目录中写入数据文件
+func writeFeederFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFeeder(t *testing.T) {
+	csvPath := writeFeederFile(t, "users.csv", "id,name\n1,alice\n2,\"bob, jr\"\n")
+	jsonlPath := writeFeederFile(t, "users.jsonl", "{\"id\": 1, \"name\": \"alice\"}\n\n{\"id\": 2, \"name\": \"bob, jr\", \"tags\": [\"a\"]}\n")
+	for _, path := range []string{csvPath, jsonlPath} {
+		feeder, err := LoadFeeder(path, FEED_SEQUENTIAL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, _ := feeder.Next()
+		second, _ := feeder.Next()
+		if first["id"] != "1" || first["name"] != "alice" || second["id"] != "2" || second["name"] != "bob, jr" {
+			t.Fatalf("%s: unexpected records: %v, %v", path, first, second)
+		}
+		if _, err := feeder.Next(); err != ErrFeederExhausted {
+			t.Fatalf("%s: expected exhaustion, got %v", path, err)
+		}
+		feeder.Reset()
+		if r, err := feeder.Next(); err != nil || r["id"] != "1" {
+			t.Fatalf("%s: unexpected record after reset: %v (%v)", path, r, err)
+		}
+	}
+
+	for _, path := range []string{
+		writeFeederFile(t, "bad.csv", "id,name\n1\n"),
+		writeFeederFile(t, "bad.jsonl", "{\"id\": 1}\n[1]\n"),
+		writeFeederFile(t, "empty.csv", "id\n"),
+		writeFeederFile(t, "users.txt", "id\n1\n"),
+	} {
+		if _, err := LoadFeeder(path, ""); err == nil {
+			t.Fatalf("Expected an error loading %s!", path)
+		}
+	}
+	if _, err := NewFeeder([]Record{{}}, "shuffle"); err == nil {
+		t.Fatal("Expected an error with unknown strategy!")
+	}
+}
+
+func TestFeederStrategies(t *testing.T) {
+	var records []Record
+	for _, id := range []string{"a", "b", "c"} {
+		records = append(records, Record{"id": id})
+	}
+	circular, _ := NewFeeder(records, "")
+	var ids string
+	for i := 0; i < 5; i++ {
+		r, _ := circular.Next()
+		ids += r["id"]
+	}
+	if ids != "abcab" || circular.Remaining() != -1 {
+		t.Fatalf("Unexpected circular order: %s", ids)
+	}
+	random, _ := NewFeeder(records, FEED_RANDOM)
+	for i := 0; i < 10; i++ {
+		if _, err := random.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 并发读取FEED_UNIQUE策略的数据源时每条记录恰好读取一次
+	records = nil
+	for i := 0; i < 1000; i++ {
+		records = append(records, Record{"n": strconv.Itoa(i)})
+	}
+	unique, _ := NewFeeder(records, FEED_UNIQUE)
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				r, err := unique.Next()
+				if err == ErrFeederExhausted {
+					return
+				}
+				mu.Lock()
+				if seen[r["n"]] {
+					t.Errorf("Duplicate record: %v", r)
+				}
+				seen[r["n"]] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != len(records) || unique.Remaining() != 0 {
+		t.Fatalf("Unexpected unique reads: %d (remaining=%d)", len(seen), unique.Remaining())
+	}
+}
+
+func TestFeedTemplateFunc(t *testing.T) {
+	feeder, _ := NewFeeder([]Record{{"id": "7", "q": "shoes"}}, FEED_SEQUENTIAL)
+	RegisterFeeder("test.search", feeder)
+	if out := execTemplate(t, `{{with feed "test.search"}}{{.id}}:{{.q}}{{end}}`); out != "7:shoes" {
+		t.Fatalf("Unexpected output: %s", out)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic on duplicate registration!")
+		}
+	}()
+	RegisterFeeder("test.search", feeder)
+}
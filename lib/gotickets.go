@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"context"
 	"errors"
 	"fmt"
 )
@@ -9,6 +10,8 @@ import (
 type GoTickets interface {
 	// 拿走一张票
 	Take()
+	// 在ctx结束前拿走一张票,未拿到时返回ctx的错误
+	TakeContext(ctx context.Context) error
 	// 归还一张票
 	Return()
 	// 票池是否已被激活
@@ -61,6 +64,16 @@ func (gt *myGoTickets) Take() {
 	<-gt.ticketCh
 }
 
+// TakeContext 在ctx结束前拿走一张票
+func (gt *myGoTickets) TakeContext(ctx context.Context) error {
+	select {
+	case <-gt.ticketCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Return 归还一张票
 func (gt *myGoTickets) Return() {
 	gt.ticketCh <- struct{}{}
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+)
+
+// Middleware 代表调用器的中间件,用于在不修改调用器的情况下附加通用的行为
+type Middleware func(next Caller) Caller
+
+// CallerHooks 表示中间件在调用器各方法上的钩子,为nil的钩子直接调用下一层
+type CallerHooks struct {
+	BuildReq  func(next func() RawReq) RawReq
+	Call      func(req []byte, timeoutNS time.Duration, next func([]byte, time.Duration) ([]byte, error)) ([]byte, error)
+	CheckResp func(rawReq RawReq, rawResp RawResp, next func(RawReq, RawResp) *CallResult) *CallResult
+}
+
+// hookedCaller 表示被钩子包装后的调用器
+type hookedCaller struct {
+	next  Caller
+	hooks CallerHooks
+}
+
+// HookMiddleware 依据钩子创建一个中间件
+func HookMiddleware(hooks CallerHooks) Middleware {
+	return func(next Caller) Caller {
+		return &hookedCaller{next: next, hooks: hooks}
+	}
+}
+
+// Chain 用中间件依次包装调用器,第一个中间件位于最外层
+func Chain(caller Caller, middlewares ...Middleware) Caller {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		caller = middlewares[i](caller)
+	}
+	return caller
+}
+
+// UnwrapCaller 返回被中间件包装的最内层调用器
+func UnwrapCaller(caller Caller) Caller {
+	for {
+		hc, ok := caller.(*hookedCaller)
+		if !ok {
+			return caller
+		}
+		caller = hc.next
+	}
+}
+
+// BuildReq 构建请求
+func (hc *hookedCaller) BuildReq() RawReq {
+	if hc.hooks.BuildReq == nil {
+		return hc.next.BuildReq()
+	}
+	return hc.hooks.BuildReq(hc.next.BuildReq)
+}
+
+// Call 调用
+func (hc *hookedCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	if hc.hooks.Call == nil {
+		return hc.next.Call(req, timeoutNS)
+	}
+	return hc.hooks.Call(req, timeoutNS, hc.next.Call)
+}
+
+// CheckResp 检查响应
+func (hc *hookedCaller) CheckResp(rawReq RawReq, rawResp RawResp) *CallResult {
+	if hc.hooks.CheckResp == nil {
+		return hc.next.CheckResp(rawReq, rawResp)
+	}
+	return hc.hooks.CheckResp(rawReq, rawResp, hc.next.CheckResp)
+}
+
+// LoggingMiddleware 记录调用错误和未成功的调用结果
+func LoggingMiddleware(logger MyLogger) Middleware {
+	return HookMiddleware(CallerHooks{
+		Call: func(req []byte, timeoutNS time.Duration, next func([]byte, time.Duration) ([]byte, error)) ([]byte, error) {
+			resp, err := next(req, timeoutNS)
+			if err != nil {
+				logger.Warnf("Call error: %s (req=%s)", err, req)
+			}
+			return resp, err
+		},
+		CheckResp: func(rawReq RawReq, rawResp RawResp, next func(RawReq, RawResp) *CallResult) *CallResult {
+			result := next(rawReq, rawResp)
+			if result.Code != RET_CODE_SUCCESS {
+				logger.Warnf("Unsuccessful result: ID=%d, Code=%d, Msg=%s", result.ID, result.Code, result.Msg)
+			}
+			return result
+		},
+	})
+}
+
+// ErrInjectedFault 表示故障注入中间件产生的错误
+var ErrInjectedFault = errors.New("Injected fault!")
+
+// FaultConfig 表示故障注入的配置
+type FaultConfig struct {
+	ErrorRate float64       // 调用直接返回ErrInjectedFault的比例,取值为[0, 1]
+	DelayRate float64       // 调用前附加延迟的比例,取值为[0, 1]
+	Delay     time.Duration // 附加的延迟,计入调用超时时间
+}
+
+// FaultInjectionMiddleware 按比例为调用注入错误和延迟
+func FaultInjectionMiddleware(cfg FaultConfig) Middleware {
+	return HookMiddleware(CallerHooks{
+		Call: func(req []byte, timeoutNS time.Duration, next func([]byte, time.Duration) ([]byte, error)) ([]byte, error) {
+			if cfg.DelayRate > 0 && rand.Float64() < cfg.DelayRate {
+				if cfg.Delay >= timeoutNS {
+					time.Sleep(timeoutNS)
+					return nil, fmt.Errorf("Injected delay exceeds timeout! (delay=%v)", cfg.Delay)
+				}
+				time.Sleep(cfg.Delay)
+				timeoutNS -= cfg.Delay
+			}
+			if cfg.ErrorRate > 0 && rand.Float64() < cfg.ErrorRate {
+				return nil, ErrInjectedFault
+			}
+			return next(req, timeoutNS)
+		},
+	})
+}
+
+// editRequest 通过最内层调用器的RequestEditor改写请求,调用器未实现该接口时返回错误
+func editRequest(caller Caller, req []byte, name string, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	inner := UnwrapCaller(caller)
+	editor, ok := inner.(RequestEditor)
+	if !ok {
+		return nil, fmt.Errorf("%s middleware can not edit requests of %T!", name, inner)
+	}
+	return editor.EditRequest(req, edit)
+}
+
+// GzipMiddleware 以gzip压缩发出的载荷,并解压gzip格式的响应;协议支持头部时设置Content-Encoding
+// 载荷在调用时才被压缩,因此检查响应时看到的仍是原始请求;调用器须实现RequestEditor
+func GzipMiddleware() Middleware {
+	return func(next Caller) Caller {
+		compress := func(payload []byte, header map[string]string) ([]byte, error) {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(payload)
+			if err := zw.Close(); err != nil {
+				return nil, err
+			}
+			if header != nil {
+				header["Content-Encoding"] = "gzip"
+			}
+			return buf.Bytes(), nil
+		}
+		return HookMiddleware(CallerHooks{
+			Call: func(req []byte, timeoutNS time.Duration, call func([]byte, time.Duration) ([]byte, error)) ([]byte, error) {
+				req, err := editRequest(next, req, "Gzip", compress)
+				if err != nil {
+					return nil, err
+				}
+				resp, err := call(req, timeoutNS)
+				if err != nil || len(resp) < 2 || resp[0] != 0x1f || resp[1] != 0x8b {
+					return resp, err
+				}
+				zr, err := gzip.NewReader(bytes.NewReader(resp))
+				if err != nil {
+					return nil, err
+				}
+				defer zr.Close()
+				return ioutil.ReadAll(zr)
+			},
+		})(next)
+	}
+}
+
+// SigningMiddleware 在调用前用sign处理发出的载荷,如附加签名或设置签名头部
+// 传给sign的header为nil表示协议不支持头部;调用器须实现RequestEditor
+func SigningMiddleware(sign func(payload []byte, header map[string]string) ([]byte, error)) Middleware {
+	return func(next Caller) Caller {
+		return HookMiddleware(CallerHooks{
+			Call: func(req []byte, timeoutNS time.Duration, call func([]byte, time.Duration) ([]byte, error)) ([]byte, error) {
+				signed, err := editRequest(next, req, "Signing", sign)
+				if err != nil {
+					return nil, err
+				}
+				return call(signed, timeoutNS)
+			},
+		})(next)
+	}
+}
+
+// TracingMiddleware 为每个请求生成追踪ID,通过inject写入请求,并记录在调用结果的标签中
+// 追踪ID由请求ID导出;参数inject为nil时只记录标签,否则调用器须实现RequestEditor
+// 传给inject的header为nil表示协议不支持头部,写入头部可使用TraceHeader
+func TracingMiddleware(inject func(payload []byte, header map[string]string, traceID string) ([]byte, error)) Middleware {
+	return func(next Caller) Caller {
+		return HookMiddleware(CallerHooks{
+			BuildReq: func(build func() RawReq) RawReq {
+				rawReq := build()
+				if inject == nil || rawReq.Err != nil {
+					return rawReq
+				}
+				id := traceID(rawReq.ID)
+				req, err := editRequest(next, rawReq.Req, "Tracing", func(payload []byte, header map[string]string) ([]byte, error) {
+					return inject(payload, header, id)
+				})
+				if err != nil {
+					rawReq.Err = err
+				} else {
+					rawReq.Req = req
+				}
+				return rawReq
+			},
+			CheckResp: func(rawReq RawReq, rawResp RawResp, check func(RawReq, RawResp) *CallResult) *CallResult {
+				result := check(rawReq, rawResp)
+				result.SetTag(TAG_TRACE, traceID(rawReq.ID))
+				return result
+			},
+		})(next)
+	}
+}
+
+// TraceHeader 返回把追踪ID写入名为name的头部的inject函数,协议不支持头部时返回错误
+func TraceHeader(name string) func(payload []byte, header map[string]string, traceID string) ([]byte, error) {
+	return func(payload []byte, header map[string]string, traceID string) ([]byte, error) {
+		if header == nil {
+			return nil, fmt.Errorf("Protocol does not support header %s!", name)
+		}
+		header[name] = traceID
+		return payload, nil
+	}
+}
+
+// traceID 由请求ID导出追踪ID
+func traceID(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
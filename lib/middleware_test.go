@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoCaller 表示把请求原样作为响应的调用器
+type echoCaller struct {
+	calls [][]byte // 收到的请求
+}
+
+func (c *echoCaller) BuildReq() RawReq {
+	return RawReq{ID: 1, Req: []byte("hello")}
+}
+
+func (c *echoCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	c.calls = append(c.calls, req)
+	return req, nil
+}
+
+func (c *echoCaller) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	return edit(req, nil)
+}
+
+func (c *echoCaller) CheckResp(rawReq RawReq, rawResp RawResp) *CallResult {
+	return &CallResult{ID: rawReq.ID, Code: RET_CODE_SUCCESS, Msg: string(rawResp.Resp)}
+}
+
+// opaqueCaller 表示未实现RequestEditor的调用器
+type opaqueCaller struct{ Caller }
+
+// callThrough 完成一次完整的构建、调用和检查
+func callThrough(caller Caller) (*CallResult, error) {
+	rawReq := caller.BuildReq()
+	resp, err := caller.Call(rawReq.Req, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return caller.CheckResp(rawReq, RawResp{ID: rawReq.ID, Resp: resp}), nil
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return HookMiddleware(CallerHooks{
+			Call: func(req []byte, timeoutNS time.Duration, next func([]byte, time.Duration) ([]byte, error)) ([]byte, error) {
+				order = append(order, name)
+				return next(append(req, name...), timeoutNS)
+			},
+		})
+	}
+	inner := &echoCaller{}
+	caller := Chain(inner, trace("a"), trace("b"))
+	result, err := callThrough(caller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "a,b" || result.Msg != "helloab" {
+		t.Fatalf("Unexpected order: %v (msg=%s)", order, result.Msg)
+	}
+	if UnwrapCaller(caller) != Caller(inner) {
+		t.Fatal("UnwrapCaller did not return the innermost caller!")
+	}
+}
+
+func TestBuiltinMiddlewares(t *testing.T) {
+	inner := &echoCaller{}
+	caller := Chain(inner,
+		TracingMiddleware(func(payload []byte, header map[string]string, id string) ([]byte, error) {
+			return append(payload, " "+id...), nil
+		}),
+		SigningMiddleware(func(payload []byte, header map[string]string) ([]byte, error) {
+			return append(payload, "|sig"...), nil
+		}),
+		GzipMiddleware(),
+	)
+	result, err := callThrough(caller)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tag(TAG_TRACE) != "0000000000000001" {
+		t.Fatalf("Unexpected trace tag: %v", result.Tags)
+	}
+	// 回显的响应是压缩后的请求,经中间件解压后得到签名后的请求
+	if result.Msg != "hello 0000000000000001|sig" {
+		t.Fatalf("Unexpected message: %s", result.Msg)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(inner.calls[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, _ := ioutil.ReadAll(zr)
+	if string(sent) != "hello 0000000000000001|sig" {
+		t.Fatalf("Unexpected request on the wire: %s", sent)
+	}
+
+	// 不支持头部的调用器无法写入追踪头部
+	rawReq := Chain(&echoCaller{}, TracingMiddleware(TraceHeader("traceparent"))).BuildReq()
+	if rawReq.Err == nil {
+		t.Fatal("Expected an error without header support!")
+	}
+	// 未实现RequestEditor的调用器不能使用改写请求的中间件
+	opaque := Chain(opaqueCaller{&echoCaller{}}, GzipMiddleware())
+	if _, err := callThrough(opaque); err == nil || !strings.Contains(err.Error(), "can not edit requests") {
+		t.Fatalf("Expected an edit error, got: %v", err)
+	}
+
+	faulty := Chain(&echoCaller{}, FaultInjectionMiddleware(FaultConfig{ErrorRate: 1}))
+	if _, err := callThrough(faulty); err != ErrInjectedFault {
+		t.Fatalf("Expected an injected fault, got: %v", err)
+	}
+	slow := Chain(&echoCaller{}, FaultInjectionMiddleware(FaultConfig{DelayRate: 1, Delay: 20 * time.Millisecond}))
+	start := time.Now()
+	if _, err := callThrough(slow); err != nil || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("Expected an injected delay! (err=%v)", err)
+	}
+}
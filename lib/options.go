@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Options 表示调用器等组件的键值选项
+// 从配置文件解码时,任意标量值都会被转换为字符串
+type Options map[string]string
+
+// UnmarshalJSON 从JSON对象解码选项,值可以是字符串、数值、布尔值或null
+func (o *Options) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	opts := make(Options, len(raw))
+	for k, v := range raw {
+		switch val := v.(type) {
+		case nil:
+			opts[k] = ""
+		case string:
+			opts[k] = val
+		case float64:
+			opts[k] = strconv.FormatFloat(val, 'f', -1, 64)
+		case bool:
+			opts[k] = strconv.FormatBool(val)
+		default:
+			return fmt.Errorf("Invalid option %q: scalar value expected!", k)
+		}
+	}
+	*o = opts
+	return nil
+}
+
+// String 获取字符串选项,不存在时返回def
+func (o Options) String(key, def string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Int 获取整数选项,不存在时返回def
+func (o Options) Int(key string, def int) (int, error) {
+	v, ok := o[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def, fmt.Errorf("Invalid option %q: integer expected, got %q!", key, v)
+	}
+	return n, nil
+}
+
+// Bool 获取布尔选项,不存在时返回def
+func (o Options) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("Invalid option %q: boolean expected, got %q!", key, v)
+	}
+	return b, nil
+}
+
+// Duration 获取时长选项(如"30s"),不存在时返回def
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := o[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, fmt.Errorf("Invalid option %q: duration expected, got %q!", key, v)
+	}
+	return d, nil
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CallerFactory 表示依据目标地址和选项构建调用器的工厂函数
+type CallerFactory func(target *url.URL, opts Options) (Caller, error)
+
+// 已注册的调用器工厂,键为小写的URL协议名
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]CallerFactory)
+)
+
+// RegisterCaller 以URL协议名注册调用器工厂,通常在实现调用器的包的init函数中调用
+// 协议名重复注册或工厂为nil时会引发恐慌
+func RegisterCaller(scheme string, factory CallerFactory) {
+	scheme = strings.ToLower(scheme)
+	if factory == nil {
+		panic("lib: RegisterCaller factory is nil")
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if _, dup := factories[scheme]; dup {
+		panic("lib: RegisterCaller called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// LookupCaller 查找协议名对应的调用器工厂
+func LookupCaller(scheme string) (CallerFactory, bool) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	factory, ok := factories[strings.ToLower(scheme)]
+	return factory, ok
+}
+
+// CallerSchemes 返回已注册的协议名,按字母顺序排列
+func CallerSchemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// NewCaller 依据目标URL的协议名选择工厂并构建调用器,如"tcp://127.0.0.1:8080"
+func NewCaller(target string, opts Options) (Caller, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid target: %s", err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("Missing scheme in target: %s!", target)
+	}
+	factory, ok := LookupCaller(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("Unknown caller scheme: %s! (registered: %s)",
+			u.Scheme, strings.Join(CallerSchemes(), ", "))
+	}
+	return factory(u, opts)
+}
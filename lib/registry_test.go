@@ -0,0 +1,36 @@
+package lib
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCallerRegistry(t *testing.T) {
+	var got *url.URL
+	RegisterCaller("Echo", func(target *url.URL, opts Options) (Caller, error) {
+		got = target
+		return &echoCaller{}, nil
+	})
+	caller, err := NewCaller("echo://host:1/path", Options{"k": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := caller.(*echoCaller); !ok || got.Host != "host:1" || got.Path != "/path" {
+		t.Fatalf("Unexpected caller: %T (target=%v)", caller, got)
+	}
+	if _, ok := LookupCaller("ECHO"); !ok {
+		t.Fatal("Scheme lookup should be case-insensitive!")
+	}
+	if _, err := NewCaller("nope://host", nil); err == nil {
+		t.Fatal("Expected an error with unknown scheme!")
+	}
+	if _, err := NewCaller("host:1", nil); err == nil {
+		t.Fatal("Expected an error without scheme!")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected a panic on duplicate registration!")
+		}
+	}()
+	RegisterCaller("echo", func(*url.URL, Options) (Caller, error) { return nil, nil })
+}
@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// SessionVars 表示会话内各步骤之间共享的变量
+type SessionVars map[string]string
+
+// Step 表示会话中的一个步骤
+type Step interface {
+	// 步骤名称
+	Name() string
+	// 依据会话变量构建请求
+	BuildReq(vars SessionVars) RawReq
+	// 调用
+	Call(req []byte, timeoutNS time.Duration) ([]byte, error)
+	// 检查响应,可从响应中提取变量写入vars供后续步骤使用
+	CheckResp(rawReq RawReq, rawResp RawResp, vars SessionVars) *CallResult
+}
+
+// StepResult 表示会话中单个步骤的执行结果
+type StepResult struct {
+	Name   string            // 步骤名称
+	ID     int64             // 步骤请求ID
+	Req    []byte            // 步骤请求
+	Resp   []byte            // 步骤响应
+	Code   RetCode           // 响应代码
+	Msg    string            // 结果成因的简述
+	Elapse time.Duration     // 耗时
+	Tags   map[string]string // 标签
+}
+
+// sessionRecord 表示一次会话的执行记录,作为会话调用器的原生响应
+type sessionRecord struct {
+	ID    int64
+	Steps []StepResult
+	Vars  SessionVars
+}
+
+// SessionCaller 表示多步骤会话的调用器
+// 每次调用依次执行全部步骤,前一步骤提取的变量可被后续步骤使用
+type SessionCaller struct {
+	steps []Step // 步骤列表
+	seq   int64  // 会话序号
+}
+
+// NewSessionCaller 新建一个多步骤会话调用器
+func NewSessionCaller(steps ...Step) (Caller, error) {
+	if len(steps) == 0 {
+		return nil, errors.New("The session caller requires at least one step!")
+	}
+	for i, step := range steps {
+		if step == nil {
+			return nil, fmt.Errorf("Invalid session step! (index=%d)", i)
+		}
+	}
+	return &SessionCaller{steps: steps}, nil
+}
+
+// BuildReq 构建一个会话请求
+func (sc *SessionCaller) BuildReq() RawReq {
+	id := atomic.AddInt64(&sc.seq, 1)
+	return RawReq{ID: id, Req: []byte(fmt.Sprintf("session-%d", id))}
+}
+
+// Call 依次执行会话的全部步骤,超时时间作用于整个会话
+func (sc *SessionCaller) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	record := sessionRecord{Vars: SessionVars{}}
+	if _, err := fmt.Sscanf(string(req), "session-%d", &record.ID); err != nil {
+		return nil, fmt.Errorf("Incorrectly formatted session req: %s!", string(req))
+	}
+	deadline := time.Now().Add(timeoutNS)
+	for _, step := range sc.steps {
+		result := sc.runStep(step, record.Vars, deadline)
+		record.Steps = append(record.Steps, result)
+		if result.Code != RET_CODE_SUCCESS {
+			break
+		}
+	}
+	return json.Marshal(record)
+}
+
+// runStep 执行单个步骤
+func (sc *SessionCaller) runStep(step Step, vars SessionVars, deadline time.Time) StepResult {
+	rawReq := step.BuildReq(vars)
+	result := StepResult{Name: step.Name(), ID: rawReq.ID, Req: rawReq.Req}
+	remain := time.Until(deadline)
+	if remain <= 0 {
+		result.Code = RET_CODE_WARNING_CALL_TIMEOUT
+		result.Msg = "Timeout! (no time left for the step)"
+		return result
+	}
+	start := time.Now()
+	resp, err := step.Call(rawReq.Req, remain)
+	result.Elapse = time.Since(start)
+	result.Resp = resp
+	if err != nil {
+		result.Code = RET_CODE_ERROR_CALL
+		result.Msg = fmt.Sprintf("Sync Call Error: %s.", err)
+		return result
+	}
+	callResult := step.CheckResp(rawReq, RawResp{
+		ID:     rawReq.ID,
+		Resp:   resp,
+		Elapse: result.Elapse,
+	}, vars)
+	if callResult == nil {
+		result.Code = RET_CODE_FATAL_CALL
+		result.Msg = "Nil step result!"
+		return result
+	}
+	result.Code = callResult.Code
+	result.Msg = callResult.Msg
+	result.Tags = callResult.Tags
+	return result
+}
+
+// CheckResp 检查会话响应,会话结果代码取首个未成功步骤的结果代码
+func (sc *SessionCaller) CheckResp(rawReq RawReq, rawResp RawResp) *CallResult {
+	result := &CallResult{
+		ID:   rawReq.ID,
+		Req:  rawReq,
+		Resp: rawResp,
+	}
+	var record sessionRecord
+	if err := json.Unmarshal(rawResp.Resp, &record); err != nil {
+		result.Code = RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incorrectly formatted session resp: %s!", err)
+		return result
+	}
+	for _, step := range record.Steps {
+		if step.Code != RET_CODE_SUCCESS {
+			result.Code = step.Code
+			result.Msg = fmt.Sprintf("Session aborted at step %s: %s", step.Name, step.Msg)
+			result.SetTag(TAG_STEP, step.Name)
+			return result
+		}
+	}
+	if len(record.Steps) != len(sc.steps) {
+		result.Code = RET_CODE_ERROR_RESPONSE
+		result.Msg = fmt.Sprintf("Incomplete session! (%d/%d steps)",
+			len(record.Steps), len(sc.steps))
+		return result
+	}
+	result.Code = RET_CODE_SUCCESS
+	result.Msg = fmt.Sprintf("Success. (%d steps)", len(record.Steps))
+	return result
+}
+
+// GetStepResults 从会话调用结果中取出各步骤的执行结果
+func GetStepResults(result *CallResult) ([]StepResult, error) {
+	if result == nil {
+		return nil, errors.New("Nil call result!")
+	}
+	var record sessionRecord
+	if err := json.Unmarshal(result.Resp.Resp, &record); err != nil {
+		return nil, err
+	}
+	return record.Steps, nil
+}
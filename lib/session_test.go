@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStep 表示测试用的会话步骤
+type testStep struct {
+	name  string
+	call  func(req []byte) ([]byte, error)
+	check func(resp []byte, vars SessionVars) RetCode
+	build func(vars SessionVars) []byte
+}
+
+func (s *testStep) Name() string {
+	return s.name
+}
+
+func (s *testStep) BuildReq(vars SessionVars) RawReq {
+	return RawReq{ID: time.Now().UnixNano(), Req: s.build(vars)}
+}
+
+func (s *testStep) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	return s.call(req)
+}
+
+func (s *testStep) CheckResp(rawReq RawReq, rawResp RawResp, vars SessionVars) *CallResult {
+	return &CallResult{ID: rawReq.ID, Code: s.check(rawResp.Resp, vars)}
+}
+
+func TestSessionCaller(t *testing.T) {
+	login := &testStep{
+		name:  "login",
+		build: func(vars SessionVars) []byte { return []byte("user=tom") },
+		call:  func(req []byte) ([]byte, error) { return []byte("token-123"), nil },
+		check: func(resp []byte, vars SessionVars) RetCode {
+			vars["token"] = string(resp)
+			return RET_CODE_SUCCESS
+		},
+	}
+	list := &testStep{
+		name:  "list",
+		build: func(vars SessionVars) []byte { return []byte("token=" + vars["token"]) },
+		call: func(req []byte) ([]byte, error) {
+			if string(req) != "token=token-123" {
+				return []byte("denied"), nil
+			}
+			return []byte("ok"), nil
+		},
+		check: func(resp []byte, vars SessionVars) RetCode {
+			if string(resp) != "ok" {
+				return RET_CODE_ERROR_RESPONSE
+			}
+			return RET_CODE_SUCCESS
+		},
+	}
+	caller, err := NewSessionCaller(login, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawReq := caller.BuildReq()
+	resp, err := caller.Call(rawReq.Req, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := caller.CheckResp(rawReq, RawResp{ID: rawReq.ID, Resp: resp})
+	if result.Code != RET_CODE_SUCCESS {
+		t.Fatalf("Unexpected session result: %v", result)
+	}
+	steps, err := GetStepResults(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[1].Name != "list" {
+		t.Fatalf("Unexpected step results: %v", steps)
+	}
+
+	// 首个步骤失败时会话应当中止
+	login.call = func(req []byte) ([]byte, error) { return nil, errors.New("refused") }
+	rawReq = caller.BuildReq()
+	resp, _ = caller.Call(rawReq.Req, time.Second)
+	result = caller.CheckResp(rawReq, RawResp{ID: rawReq.ID, Resp: resp})
+	if result.Code != RET_CODE_ERROR_CALL || !strings.Contains(result.Msg, "login") {
+		t.Fatalf("Unexpected session result: %v", result)
+	}
+	steps, _ = GetStepResults(result)
+	if len(steps) != 1 {
+		t.Fatalf("Unexpected step count: %d", len(steps))
+	}
+}
@@ -0,0 +1,213 @@
+package lib
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// 模板生成器的状态,在进程内的所有模板间共享
+var (
+	templateCounters sync.Map // 命名序列计数器,值为*int64
+	templateWeights  sync.Map // 已解析的加权选择,值为*weightedChoice
+	templateZipfMu   sync.Mutex
+	templateZipfs    = make(map[zipfKey]*mrand.Zipf) // Zipf分布生成器
+)
+
+// TemplateFuncs 返回请求模板中可使用的值生成函数,可通过template.Template.Funcs添加到任意模板
+// 带名称或参数的有状态生成器(如seq、zipf)在进程内共享,同名序列在不同模板中连续计数
+//
+//	seq name                  名为name的序列的下一个值,从1开始
+//	seqRange name min max     名为name的序列在[min, max)内循环的值
+//	randInt min max           [min, max)范围内的随机整数
+//	randFloat min max         [min, max)范围内的随机浮点数
+//	randString n              长度为n的随机字母数字字符串
+//	randStringRange min max   长度在[min, max]范围内的随机字母数字字符串
+//	uuid                      随机生成的UUID(版本4)
+//	now                       当前时间,可使用Format等方法
+//	timestamp unit            当前时间戳,unit为s、ms、us或ns
+//	choice values...          等概率地从参数中选择一个
+//	weighted spec             按权重选择,如"hit:9,miss:1"
+//	zipf s max                服从Zipf分布的[0, max]范围内的整数,s大于1,越小的值越热
+//	feed name                 从以RegisterFeeder注册的数据源读取下一条记录,如{{(feed "users").id}}
+//	                          callers包的调用器在构建同一请求时对同名数据源只读取一次
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"seq":             templateSeq,
+		"seqRange":        templateSeqRange,
+		"randInt":         templateRandInt,
+		"randFloat":       templateRandFloat,
+		"randString":      templateRandString,
+		"randStringRange": templateRandStringRange,
+		"uuid":            templateUUID,
+		"now":             time.Now,
+		"timestamp":       templateTimestamp,
+		"choice":          templateChoice,
+		"weighted":        templateWeighted,
+		"zipf":            templateZipf,
+		"feed":            Feed,
+	}
+}
+
+// templateSeq 返回命名序列的下一个值
+func templateSeq(name string) int64 {
+	v, _ := templateCounters.LoadOrStore(name, new(int64))
+	return atomic.AddInt64(v.(*int64), 1)
+}
+
+// templateSeqRange 返回命名序列在[min, max)内循环的值
+func templateSeqRange(name string, min, max int64) int64 {
+	if max <= min {
+		return min
+	}
+	return min + (templateSeq(name)-1)%(max-min)
+}
+
+// templateRandInt 返回[min, max)范围内的随机整数
+func templateRandInt(min, max int) int {
+	if max <= min {
+		return min
+	}
+	return min + mrand.Intn(max-min)
+}
+
+// templateRandFloat 返回[min, max)范围内的随机浮点数
+func templateRandFloat(min, max float64) float64 {
+	if max <= min {
+		return min
+	}
+	return min + mrand.Float64()*(max-min)
+}
+
+// templateRandString 返回长度为n的随机字母数字字符串
+func templateRandString(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	if n <= 0 {
+		return ""
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = letters[mrand.Intn(len(letters))]
+	}
+	return string(buf)
+}
+
+// templateRandStringRange 返回长度在[min, max]范围内的随机字母数字字符串
+func templateRandStringRange(min, max int) string {
+	return templateRandString(templateRandInt(min, max+1))
+}
+
+// templateUUID 返回随机生成的UUID(版本4)
+func templateUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// templateTimestamp 返回以unit为单位的当前时间戳
+func templateTimestamp(unit string) (int64, error) {
+	now := time.Now()
+	switch unit {
+	case "s":
+		return now.Unix(), nil
+	case "ms":
+		return now.UnixMilli(), nil
+	case "us":
+		return now.UnixMicro(), nil
+	case "ns":
+		return now.UnixNano(), nil
+	}
+	return 0, fmt.Errorf("Invalid timestamp unit: %q!", unit)
+}
+
+// templateChoice 等概率地从参数中选择一个
+func templateChoice(values ...interface{}) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, errors.New("Invalid choice: no values!")
+	}
+	return values[mrand.Intn(len(values))], nil
+}
+
+// weightedChoice 表示解析后的加权选择
+type weightedChoice struct {
+	values []string
+	cum    []int // 累计权重
+}
+
+// parseWeighted 解析形如"a:3,b:1"的加权选择,值与权重以最后一个冒号分隔
+func parseWeighted(spec string) (*weightedChoice, error) {
+	wc := &weightedChoice{}
+	total := 0
+	for _, item := range strings.Split(spec, ",") {
+		i := strings.LastIndex(item, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid weighted item: %q!", item)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(item[i+1:]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("Invalid weight of item: %q!", item)
+		}
+		total += weight
+		wc.values = append(wc.values, strings.TrimSpace(item[:i]))
+		wc.cum = append(wc.cum, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("Invalid weighted spec: %q! (total weight is 0)", spec)
+	}
+	return wc, nil
+}
+
+// templateWeighted 按权重选择一个值,解析结果按spec缓存
+func templateWeighted(spec string) (string, error) {
+	v, ok := templateWeights.Load(spec)
+	if !ok {
+		wc, err := parseWeighted(spec)
+		if err != nil {
+			return "", err
+		}
+		v, _ = templateWeights.LoadOrStore(spec, wc)
+	}
+	wc := v.(*weightedChoice)
+	n := mrand.Intn(wc.cum[len(wc.cum)-1])
+	for i, c := range wc.cum {
+		if n < c {
+			return wc.values[i], nil
+		}
+	}
+	return wc.values[len(wc.values)-1], nil
+}
+
+// zipfKey 表示Zipf分布生成器的参数
+type zipfKey struct {
+	s   float64
+	max uint64
+}
+
+// templateZipf 返回服从参数为s的Zipf分布的[0, max]范围内的整数
+func templateZipf(s float64, max int64) (uint64, error) {
+	if s <= 1 || max < 0 {
+		return 0, fmt.Errorf("Invalid zipf parameters! (s=%v, max=%d)", s, max)
+	}
+	key := zipfKey{s: s, max: uint64(max)}
+	templateZipfMu.Lock()
+	defer templateZipfMu.Unlock()
+	z, ok := templateZipfs[key]
+	if !ok {
+		// Zipf不支持并发使用,每个生成器持有独立的随机源,并由互斥锁保护
+		r := mrand.New(mrand.NewSource(time.Now().UnixNano()))
+		z = mrand.NewZipf(r, s, 1, key.max)
+		templateZipfs[key] = z
+	}
+	return z.Uint64(), nil
+}
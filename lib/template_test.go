@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// execTemplate 解析并渲染模板
+func execTemplate(t *testing.T, text string) string {
+	tmpl, err := template.New("test").Funcs(TemplateFuncs()).Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	if out := execTemplate(t, `{{seq "t.a"}},{{seq "t.a"}},{{seq "t.b"}}`); out != "1,2,1" {
+		t.Fatalf("Unexpected sequence: %s", out)
+	}
+	if out := execTemplate(t, `{{range 5}}{{seqRange "t.r" 10 13}} {{end}}`); out != "10 11 12 10 11 " {
+		t.Fatalf("Unexpected sequence range: %s", out)
+	}
+	out := execTemplate(t, `{{randInt 5 8}}|{{randFloat 1 2}}|{{randString 6}}|{{randStringRange 2 4}}|{{uuid}}|{{timestamp "ms"}}|{{choice "x" "y"}}|{{now.Year}}`)
+	parts := strings.Split(out, "|")
+	if n, _ := strconv.Atoi(parts[0]); n < 5 || n >= 8 {
+		t.Fatalf("Unexpected random int: %s", parts[0])
+	}
+	if f, _ := strconv.ParseFloat(parts[1], 64); f < 1 || f >= 2 {
+		t.Fatalf("Unexpected random float: %s", parts[1])
+	}
+	if len(parts[2]) != 6 || len(parts[3]) < 2 || len(parts[3]) > 4 {
+		t.Fatalf("Unexpected random strings: %q, %q", parts[2], parts[3])
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(parts[4]) {
+		t.Fatalf("Unexpected UUID: %s", parts[4])
+	}
+	if len(parts[5]) != 13 || (parts[6] != "x" && parts[6] != "y") || len(parts[7]) != 4 {
+		t.Fatalf("Unexpected output: %s", out)
+	}
+}
+
+func TestTemplateDistributions(t *testing.T) {
+	counts := make(map[string]int)
+	for _, v := range strings.Fields(execTemplate(t, `{{range 2000}}{{weighted "hit:9, miss:1, never:0"}} {{end}}`)) {
+		counts[v]++
+	}
+	if counts["never"] != 0 || counts["hit"] < 1600 || counts["miss"] < 100 {
+		t.Fatalf("Unexpected weighted counts: %v", counts)
+	}
+
+	counts = make(map[string]int)
+	for _, v := range strings.Fields(execTemplate(t, `{{range 2000}}{{zipf 1.5 99}} {{end}}`)) {
+		if n, _ := strconv.Atoi(v); n < 0 || n > 99 {
+			t.Fatalf("Zipf value out of range: %s", v)
+		}
+		counts[v]++
+	}
+	if counts["0"] < counts["1"] || counts["0"] < 500 {
+		t.Fatalf("Unexpected zipf counts: 0=%d, 1=%d", counts["0"], counts["1"])
+	}
+
+	for _, text := range []string{`{{weighted "a"}}`, `{{weighted "a:0"}}`, `{{zipf 1 10}}`, `{{timestamp "h"}}`, `{{choice}}`} {
+		tmpl := template.Must(template.New("test").Funcs(TemplateFuncs()).Parse(text))
+		if err := tmpl.Execute(&bytes.Buffer{}, nil); err == nil {
+			t.Fatalf("Expected an error from %s!", text)
+		}
+	}
+}
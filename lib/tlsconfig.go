@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions 表示客户端TLS的选项
+type TLSOptions struct {
+	CAFile             string // CA证书包(PEM)路径,为空时使用系统根证书
+	CertFile           string // 客户端证书(PEM)路径,用于双向TLS
+	KeyFile            string // 客户端私钥(PEM)路径,用于双向TLS
+	ServerName         string // SNI及证书校验使用的服务器名称
+	MinVersion         string // 最低TLS版本,取值为1.0、1.1、1.2或1.3,默认为1.2
+	DisableResumption  bool   // 是否禁用会话恢复
+	InsecureSkipVerify bool   // 是否跳过服务端证书校验,仅用于测试
+}
+
+// tlsVersions 表示可读的TLS版本与常量的对应关系
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Config 依据选项生成客户端TLS配置
+func (opts TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.ServerName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.MinVersion != "" {
+		v, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("Invalid TLS min version: %s!", opts.MinVersion)
+		}
+		cfg.MinVersion = v
+	}
+	if !opts.DisableResumption {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	} else {
+		cfg.SessionTicketsDisabled = true
+	}
+	if opts.CAFile != "" {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No valid certificate in CA file: %s!", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("Both client certificate and key are required for mutual TLS!")
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
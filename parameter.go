@@ -2,7 +2,6 @@ package loadgen
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -17,34 +16,85 @@ type ParamSet struct {
 	LPS        uint32               // 每秒载荷数
 	DurationNS time.Duration        // 负载持续时间, 单位:纳秒
 	ResultCh   chan *lib.CallResult // 调用结果通道
+	Tags       map[string]string    // 附加到每个调用结果上的标签,阶段(lib.TAG_PHASE)等由使用方设置,未设置lib.TAG_NODE时以主机名补全
+	Profile    string               // 负载模型,为空时使用PROFILE_CONSTANT
+}
+
+// 支持的负载模型
+const (
+	// PROFILE_CONSTANT 代表以恒定的每秒载荷数持续施压
+	PROFILE_CONSTANT = "constant"
+)
+
+// ParamError 表示单个参数的错误
+type ParamError struct {
+	Field string // 参数名称
+	Msg   string // 错误信息
+}
+
+func (e *ParamError) Error() string {
+	return e.Msg
+}
+
+// ParamErrors 表示参数检查发现的全部错误
+type ParamErrors []*ParamError
+
+// add 追加一个参数错误
+func (errs *ParamErrors) add(field string, format string, args ...interface{}) {
+	*errs = append(*errs, &ParamError{Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+// Error 把全部错误信息以空格连接
+func (errs ParamErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Msg
+	}
+	return strings.Join(msgs, " ")
+}
+
+// Fields 返回全部出错的参数名称
+func (errs ParamErrors) Fields() []string {
+	fields := make([]string, len(errs))
+	for i, e := range errs {
+		fields[i] = e.Field
+	}
+	return fields
 }
 
 // Check 检查当前值的所有字段的有效性
-// 若存在无效字段则返回值非nil
+// 若存在无效字段则返回值非nil,其类型为ParamErrors
 func (ps *ParamSet) Check() error {
-	var errMsgs []string
+	return ps.check(logger)
+}
+
+// check 检查所有字段的有效性,并以logger记录检查结果
+func (ps *ParamSet) check(logger lib.MyLogger) error {
+	var errs ParamErrors
 	if ps.Caller == nil {
-		errMsgs = append(errMsgs, "Invalid caller!")
+		errs.add("Caller", "Invalid caller!")
 	}
-	if ps.TimeoutNS == 0 {
-		errMsgs = append(errMsgs, "Invalid timeoutNS!")
+	if ps.TimeoutNS <= 0 {
+		errs.add("TimeoutNS", "Invalid timeoutNS!")
 	}
 	if ps.LPS == 0 {
-		errMsgs = append(errMsgs, "Invalid lps(load per second)!")
+		errs.add("LPS", "Invalid lps(load per second)!")
 	}
-	if ps.DurationNS == 0 {
-		errMsgs = append(errMsgs, "Invalid durationsNS!")
+	if ps.DurationNS <= 0 {
+		errs.add("DurationNS", "Invalid durationsNS!")
 	}
 	if ps.ResultCh == nil {
-		errMsgs = append(errMsgs, "Invalid result channel!")
+		errs.add("ResultCh", "Invalid result channel!")
+	}
+	if ps.Profile != "" && ps.Profile != PROFILE_CONSTANT {
+		errs.add("Profile", "Invalid profile: %s!", ps.Profile)
 	}
 	var buf bytes.Buffer
 	buf.WriteString("Checking the parameters...")
-	if errMsgs != nil {
-		errMsg := strings.Join(errMsgs, " ")
-		buf.WriteString(fmt.Sprintf("NOT passed! (%s)", errMsg))
+	if errs != nil {
+		buf.WriteString(fmt.Sprintf("NOT passed! (%s)", errs))
 		logger.Infoln(buf.String())
-		return errors.New(errMsg)
+		return errs
 	}
 	buf.WriteString(
 		fmt.Sprintf("Passed. (timeoutNS=%s, lps=%d, durationNS=%s)",
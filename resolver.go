@@ -0,0 +1,275 @@
+package loadgen
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"loadgen/lib"
+)
+
+// 环境变量名前缀,如LOADGEN_LPS、LOADGEN_THRESHOLDS_MAX_ERROR_RATE
+const ENV_PREFIX = "LOADGEN_"
+
+// 配置值的来源
+const (
+	SOURCE_DEFAULT = "default" // 默认值
+	SOURCE_FILE    = "file"    // 配置文件
+	SOURCE_ENV     = "env"     // 环境变量
+	SOURCE_FLAG    = "flag"    // 命令行参数
+)
+
+// configField 表示可被逐层覆盖的配置字段
+type configField struct {
+	name string                                // 字段名
+	get  func(cfg *Config) string              // 取值
+	set  func(cfg *Config, value string) error // 设值
+}
+
+// configFields 列出全部可被覆盖的标量字段
+var configFields = []configField{
+	{"target",
+		func(cfg *Config) string { return cfg.Target },
+		func(cfg *Config, v string) error { cfg.Target = v; return nil }},
+	{"caller",
+		func(cfg *Config) string { return cfg.Caller },
+		func(cfg *Config, v string) error { cfg.Caller = v; return nil }},
+	{"timeout",
+		func(cfg *Config) string { return time.Duration(cfg.Timeout).String() },
+		func(cfg *Config, v string) error { return setDuration(&cfg.Timeout, v) }},
+	{"lps",
+		func(cfg *Config) string { return strconv.FormatUint(uint64(cfg.LPS), 10) },
+		func(cfg *Config, v string) error {
+			n, err := strconv.ParseUint(v, 10, 32)
+			cfg.LPS = uint32(n)
+			return err
+		}},
+	{"duration",
+		func(cfg *Config) string { return time.Duration(cfg.Duration).String() },
+		func(cfg *Config, v string) error { return setDuration(&cfg.Duration, v) }},
+	{"profile",
+		func(cfg *Config) string { return cfg.Profile },
+		func(cfg *Config, v string) error { cfg.Profile = v; return nil }},
+	{"result_buffer",
+		func(cfg *Config) string { return strconv.Itoa(cfg.ResultBuffer) },
+		func(cfg *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			cfg.ResultBuffer = n
+			return err
+		}},
+	{"thresholds.max_error_rate",
+		func(cfg *Config) string { return strconv.FormatFloat(cfg.Thresholds.MaxErrorRate, 'f', -1, 64) },
+		func(cfg *Config, v string) error {
+			f, err := strconv.ParseFloat(v, 64)
+			cfg.Thresholds.MaxErrorRate = f
+			return err
+		}},
+	{"thresholds.max_avg_elapse",
+		func(cfg *Config) string { return time.Duration(cfg.Thresholds.MaxAvgElapse).String() },
+		func(cfg *Config, v string) error { return setDuration(&cfg.Thresholds.MaxAvgElapse, v) }},
+}
+
+// setDuration 把可读形式或以秒为单位的数值形式的时长写入d,规则与配置文件相同
+func setDuration(d *Duration, v string) error {
+	dur, err := parseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = dur
+	return nil
+}
+
+// envName 返回字段对应的环境变量名
+func envName(field string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.Replace(field, ".", "_", -1))
+}
+
+// ResolvedConfig 表示逐层合并后的有效配置及各字段值的来源
+type ResolvedConfig struct {
+	Config
+	Sources map[string]string // 字段名 -> 来源(如"env LOADGEN_LPS")
+}
+
+// DefaultConfig 返回填充了默认值的配置
+func DefaultConfig() *Config {
+	return &Config{
+		Timeout:      Duration(DEFAULT_TIMEOUT),
+		LPS:          DEFAULT_LPS,
+		Duration:     Duration(DEFAULT_DURATION),
+		Profile:      PROFILE_CONSTANT,
+		ResultBuffer: DEFAULT_RESULT_BUFFER,
+	}
+}
+
+// ResolveConfig 依次以默认值、配置文件、环境变量和命令行参数逐层覆盖得到有效配置
+// 配置文件路径取自参数-config,其次为环境变量LOADGEN_CONFIG;
+// 选项和标签可以通过LOADGEN_OPTION_<KEY>、LOADGEN_TAG_<KEY>以及
+// 可重复的-option key=value、-tag key=value覆盖
+func ResolveConfig(args []string, environ []string) (*ResolvedConfig, error) {
+	rc := &ResolvedConfig{Config: *DefaultConfig(), Sources: make(map[string]string)}
+	for _, f := range configFields {
+		rc.Sources[f.name] = SOURCE_DEFAULT
+	}
+
+	// 解析命令行参数,暂不应用
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	configPath := fs.String("config", "", "path of the YAML or JSON config file")
+	flagValues := make(map[string]*string)
+	for _, f := range configFields {
+		flagValues[f.name] = fs.String(f.name, "", "override "+f.name)
+	}
+	var flagOpts, flagTags keyValueFlag
+	fs.Var(&flagOpts, "option", "override a caller option (key=value)")
+	fs.Var(&flagTags, "tag", "override a result tag (key=value)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	flagSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, ENV_PREFIX) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	// 配置文件
+	path := *configPath
+	if !flagSet["config"] {
+		path = env[ENV_PREFIX+"CONFIG"]
+	}
+	if path != "" {
+		if err := rc.applyFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// 环境变量
+	for _, f := range configFields {
+		name := envName(f.name)
+		if v, ok := env[name]; ok {
+			if err := f.set(&rc.Config, v); err != nil {
+				return nil, fmt.Errorf("Invalid %s: %s", name, err)
+			}
+			rc.Sources[f.name] = SOURCE_ENV + " " + name
+		}
+	}
+	for name, v := range env {
+		if key := strings.TrimPrefix(name, ENV_PREFIX+"OPTION_"); key != name {
+			rc.setOption(strings.ToLower(key), v, SOURCE_ENV+" "+name)
+		} else if key := strings.TrimPrefix(name, ENV_PREFIX+"TAG_"); key != name {
+			rc.setTag(strings.ToLower(key), v, SOURCE_ENV+" "+name)
+		}
+	}
+
+	// 命令行参数
+	for _, f := range configFields {
+		if flagSet[f.name] {
+			if err := f.set(&rc.Config, *flagValues[f.name]); err != nil {
+				return nil, fmt.Errorf("Invalid -%s: %s", f.name, err)
+			}
+			rc.Sources[f.name] = SOURCE_FLAG + " -" + f.name
+		}
+	}
+	for _, kv := range flagOpts {
+		rc.setOption(kv[0], kv[1], SOURCE_FLAG+" -option")
+	}
+	for _, kv := range flagTags {
+		rc.setTag(kv[0], kv[1], SOURCE_FLAG+" -tag")
+	}
+	return rc, nil
+}
+
+// applyFile 以配置文件的内容覆盖当前配置
+func (rc *ResolvedConfig) applyFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	format, err := configFormat(path)
+	if err != nil {
+		return err
+	}
+	keys, err := decodeConfig(&rc.Config, data, format)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	for _, key := range keys {
+		rc.Sources[key] = SOURCE_FILE + " " + path
+	}
+	return nil
+}
+
+// setOption 覆盖一个调用器选项
+func (rc *ResolvedConfig) setOption(key, value, source string) {
+	if rc.Options == nil {
+		rc.Options = make(lib.Options)
+	}
+	rc.Options[key] = value
+	rc.Sources["options."+key] = source
+}
+
+// setTag 覆盖一个标签
+func (rc *ResolvedConfig) setTag(key, value, source string) {
+	if rc.Tags == nil {
+		rc.Tags = make(map[string]string)
+	}
+	rc.Tags[key] = value
+	rc.Sources["tags."+key] = source
+}
+
+// Print 按字段名顺序打印有效配置及各字段值的来源
+func (rc *ResolvedConfig) Print(w io.Writer) error {
+	values := make(map[string]string)
+	for _, f := range configFields {
+		values[f.name] = f.get(&rc.Config)
+	}
+	for k, v := range rc.Options {
+		values["options."+k] = v
+	}
+	for k, v := range rc.Tags {
+		values["tags."+k] = v
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := rc.Sources[name]
+		if source == "" {
+			source = SOURCE_DEFAULT
+		}
+		if _, err := fmt.Fprintf(w, "%s = %q (%s)\n", name, values[name], source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyValueFlag 表示可重复的key=value形式的命令行参数
+type keyValueFlag [][2]string
+
+func (f *keyValueFlag) String() string {
+	pairs := make([]string, len(*f))
+	for i, kv := range *f {
+		pairs[i] = kv[0] + "=" + kv[1]
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *keyValueFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("key=value expected, got %q", value)
+	}
+	*f = append(*f, [2]string{value[:i], value[i+1:]})
+	return nil
+}
@@ -0,0 +1,73 @@
+package loadgen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loadgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "load.yaml")
+	doc := "target: 127.0.0.1:8000\ncaller: tcp\nlps: 500\nduration: 30s\n"
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	environ := []string{
+		"LOADGEN_CONFIG=" + path,
+		"LOADGEN_LPS=800",
+		"LOADGEN_TIMEOUT=0.5",
+		"LOADGEN_OPTION_POOL_SIZE=4",
+		"HOME=/root",
+	}
+	args := []string{"-duration", "1m", "-tag", "node=n1"}
+	rc, err := ResolveConfig(args, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Target != "127.0.0.1:8000" || rc.LPS != 800 ||
+		time.Duration(rc.Duration) != time.Minute ||
+		time.Duration(rc.Timeout) != 500*time.Millisecond ||
+		rc.Options["pool_size"] != "4" || rc.Tags["node"] != "n1" {
+		t.Fatalf("Unexpected resolved config: %+v", rc.Config)
+	}
+	expected := map[string]string{
+		"target":            SOURCE_FILE + " " + path,
+		"lps":               SOURCE_ENV + " LOADGEN_LPS",
+		"duration":          SOURCE_FLAG + " -duration",
+		"timeout":           SOURCE_ENV + " LOADGEN_TIMEOUT",
+		"options.pool_size": SOURCE_ENV + " LOADGEN_OPTION_POOL_SIZE",
+		"tags.node":         SOURCE_FLAG + " -tag",
+	}
+	for field, source := range expected {
+		if rc.Sources[field] != source {
+			t.Fatalf("Unexpected source of %s: %q (expected: %q)", field, rc.Sources[field], source)
+		}
+	}
+	var buf bytes.Buffer
+	if err := rc.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `lps = "800" (env LOADGEN_LPS)`) {
+		t.Fatalf("Unexpected printed config:\n%s", buf.String())
+	}
+	// 不带单位的时长以秒为单位,与配置文件相同
+	rc, err = ResolveConfig([]string{"-timeout", "2"}, []string{"LOADGEN_DURATION=30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(rc.Timeout) != 2*time.Second || time.Duration(rc.Duration) != 30*time.Second {
+		t.Fatalf("Unexpected durations: %s, %s", time.Duration(rc.Timeout), time.Duration(rc.Duration))
+	}
+	if _, err := ResolveConfig([]string{"-lps", "many"}, nil); err == nil {
+		t.Fatal("Invalid flag value passed the resolving!")
+	}
+}
@@ -1,8 +1,8 @@
 package testhelper
 
 import (
-	"bufio"
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -21,14 +21,95 @@ var operators = []string{"+", "-", "*", "/"}
 
 // TCPComm 表示TCP通迅器的结构
 type TCPComm struct {
-	addr string // 通讯地址
+	addr string              // 通讯地址
+	pool loadgenlib.ConnPool // 连接池,为nil时每次调用新建连接
+	tls  *tls.Config         // TLS配置,为nil时使用明文连接
+	mux  *muxer              // 多路复用的连接,不为nil时并发调用共享这些连接
 }
 
 // NewTCPComm 新建一个TCP通讯器
+// 地址也可以是"unix:///path/to.sock"或"unixpacket:///path/to.sock"形式的Unix域套接字
 func NewTCPComm(addr string) loadgenlib.Caller {
 	return &TCPComm{addr: addr}
 }
 
+// NewPooledTCPComm 新建一个复用持久连接的TCP通讯器
+func NewPooledTCPComm(addr string, cfg loadgenlib.PoolConfig) (loadgenlib.Caller, error) {
+	comm := &TCPComm{addr: addr}
+	pool, err := loadgenlib.NewConnPool(comm.dial, cfg)
+	if err != nil {
+		return nil, err
+	}
+	comm.pool = pool
+	return comm, nil
+}
+
+// NewTLSTCPComm 新建一个基于TLS的TCP通讯器,提供了客户端证书时使用双向TLS
+// 参数pool不为nil时复用持久连接
+func NewTLSTCPComm(addr string, opts loadgenlib.TLSOptions, pool *loadgenlib.PoolConfig) (loadgenlib.Caller, error) {
+	cfg, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+	comm := &TCPComm{addr: addr, tls: cfg}
+	if pool != nil {
+		p, err := loadgenlib.NewConnPool(comm.dial, *pool)
+		if err != nil {
+			return nil, err
+		}
+		comm.pool = p
+	}
+	return comm, nil
+}
+
+// NewMuxTCPComm 新建一个多路复用的TCP通讯器
+// 并发的调用共享conns个连接,响应按ID分发给对应的调用,超时只影响本次调用
+func NewMuxTCPComm(addr string, conns int) (loadgenlib.Caller, error) {
+	if conns <= 0 {
+		return nil, fmt.Errorf("Invalid number of multiplexed connections: %d!", conns)
+	}
+	comm := &TCPComm{addr: addr}
+	comm.mux = newMuxer(comm.dial, conns)
+	return comm, nil
+}
+
+// NewMuxTLSTCPComm 新建一个基于TLS的多路复用TCP通讯器,每个复用的连接在建立时完成TLS握手
+func NewMuxTLSTCPComm(addr string, opts loadgenlib.TLSOptions, conns int) (loadgenlib.Caller, error) {
+	if conns <= 0 {
+		return nil, fmt.Errorf("Invalid number of multiplexed connections: %d!", conns)
+	}
+	cfg, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+	comm := &TCPComm{addr: addr, tls: cfg}
+	comm.mux = newMuxer(comm.dial, conns)
+	return comm, nil
+}
+
+// dial 建立一个新连接,启用TLS时在超时时间内完成握手
+func (comm *TCPComm) dial(timeoutNS time.Duration) (net.Conn, error) {
+	network, address, err := loadgenlib.SplitNetworkAddr(comm.addr)
+	if err != nil {
+		return nil, err
+	}
+	if comm.tls == nil {
+		return net.DialTimeout(network, address, timeoutNS)
+	}
+	dialer := &net.Dialer{Timeout: timeoutNS}
+	return tls.DialWithDialer(dialer, network, address, comm.tls)
+}
+
+// Close 关闭通讯器持有的空闲连接和多路复用的连接
+func (comm *TCPComm) Close() {
+	if comm.pool != nil {
+		comm.pool.Close()
+	}
+	if comm.mux != nil {
+		comm.mux.close()
+	}
+}
+
 // BuildReq 构建一个请求
 func (comm *TCPComm) BuildReq() loadgenlib.RawReq {
 	//设置伪随机种子
@@ -52,12 +133,24 @@ func (comm *TCPComm) BuildReq() loadgenlib.RawReq {
 	return rawReq
 }
 
+// EditRequest 原生请求即为发出的载荷,协议不支持头部
+func (comm *TCPComm) EditRequest(req []byte, edit func(payload []byte, header map[string]string) ([]byte, error)) ([]byte, error) {
+	return edit(req, nil)
+}
+
 // Call 发起一次通讯
 func (comm *TCPComm) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
-	conn, err := net.DialTimeout("tcp", comm.addr, timeoutNS)
+	if comm.pool != nil {
+		return comm.pooledCall(req, timeoutNS)
+	}
+	if comm.mux != nil {
+		return comm.muxCall(req, timeoutNS)
+	}
+	conn, err := comm.dial(timeoutNS)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
 	_, err = write(conn, req, DELIM)
 	if err != nil {
 		return nil, err
@@ -65,6 +158,41 @@ func (comm *TCPComm) Call(req []byte, timeoutNS time.Duration) ([]byte, error) {
 	return read(conn, DELIM)
 }
 
+// pooledCall 使用连接池中的连接发起一次通讯,出错的连接不再复用
+func (comm *TCPComm) pooledCall(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeoutNS)
+	conn, err := comm.pool.Get(timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	resp, err := comm.roundTrip(conn, req)
+	comm.pool.Put(conn, err != nil)
+	return resp, err
+}
+
+// muxCall 在共享的连接上发起一次通讯
+func (comm *TCPComm) muxCall(req []byte, timeoutNS time.Duration) ([]byte, error) {
+	var sReq ServerReq
+	if err := json.Unmarshal(req, &sReq); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeoutNS)
+	mc, err := comm.mux.get(timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	return mc.call(sReq.ID, req, time.Until(deadline))
+}
+
+// roundTrip 在连接上发送请求并读取响应
+func (comm *TCPComm) roundTrip(conn net.Conn, req []byte) ([]byte, error) {
+	if _, err := write(conn, req, DELIM); err != nil {
+		return nil, err
+	}
+	return read(conn, DELIM)
+}
+
 // CheckResp 检查响应内容
 func (comm *TCPComm) CheckResp(rawReq loadgenlib.RawReq, rawResp loadgenlib.RawResp) *loadgenlib.CallResult {
 	var commResult loadgenlib.CallResult
@@ -112,7 +240,16 @@ func (comm *TCPComm) CheckResp(rawReq loadgenlib.RawReq, rawResp loadgenlib.RawR
 }
 
 // read 从连接中读数据直到遇到参数delim代表的字节
+// 对于面向消息的unixpacket连接,一次读取一个完整的消息
 func read(conn net.Conn, delim byte) ([]byte, error) {
+	if conn.LocalAddr().Network() == "unixpacket" {
+		buf := make([]byte, 64*1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(buf[:n], []byte{delim}), nil
+	}
 	readBytes := make([]byte, 1)
 	var buffer bytes.Buffer
 	for {
@@ -130,14 +267,14 @@ func read(conn net.Conn, delim byte) ([]byte, error) {
 }
 
 // write 向连接写数据,并在最后追加参数delim代表的字节
+// 内容和分隔符通过一次写操作发出,以保证在unixpacket连接上构成一个消息
 func write(conn net.Conn, content []byte, delim byte) (int, error) {
-	writer := bufio.NewWriter(conn)
-	n, err := writer.Write(content)
-	if err == nil {
-		_ = writer.WriteByte(delim)
-	}
-	if err == nil {
-		err = writer.Flush()
+	buf := make([]byte, 0, len(content)+1)
+	buf = append(buf, content...)
+	buf = append(buf, delim)
+	n, err := conn.Write(buf)
+	if n > len(content) {
+		n = len(content)
 	}
 	return n, err
 }
@@ -1,7 +1,17 @@
 package testhelper
 
 import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
 	"loadgen/lib"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -38,3 +48,322 @@ func TestTCPComm(t *testing.T) {
 	<-stopSignal
 	server.Close()
 }
+
+func TestTCPCommUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loadgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, network := range []string{"unix", "unixpacket"} {
+		addr := network + "://" + filepath.Join(dir, network+".sock")
+		server := NewTCPServer()
+		if err := server.Listen(addr); err != nil {
+			t.Fatal(err)
+		}
+		comm := NewTCPComm(addr)
+		rawReq := comm.BuildReq()
+		mBytes, err := comm.Call(rawReq.Req, 50*time.Millisecond)
+		if err != nil {
+			t.Fatalf("%s: %s", network, err)
+		}
+		result := comm.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: mBytes})
+		if result.Code != lib.RET_CODE_SUCCESS {
+			t.Fatalf("%s: unexpected result: %v", network, result)
+		}
+		server.Close()
+	}
+}
+
+func TestPooledTCPComm(t *testing.T) {
+	server := NewTCPServer()
+	addr := "127.0.0.1:8082"
+	if err := server.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	caller, err := NewPooledTCPComm(addr, lib.PoolConfig{
+		Size:        2,
+		IdleTimeout: 50 * time.Millisecond,
+		HealthCheck: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm := caller.(*TCPComm)
+	defer comm.Close()
+	callOnce := func() {
+		rawReq := comm.BuildReq()
+		mBytes, err := comm.Call(rawReq.Req, 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := comm.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: mBytes})
+		if result.Code != lib.RET_CODE_SUCCESS {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		callOnce()
+	}
+	if n := comm.pool.Opened(); n != 1 {
+		t.Fatalf("Connections were not reused! (opened=%d)", n)
+	}
+	// 空闲超时的连接不再复用
+	time.Sleep(100 * time.Millisecond)
+	callOnce()
+	if n := comm.pool.Opened(); n != 2 {
+		t.Fatalf("Idle connection was reused! (opened=%d)", n)
+	}
+}
+
+func TestTLSTCPComm(t *testing.T) {
+	certPEM, keyPEM, err := GenerateCert("127.0.0.1", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "loadgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(certPEM)
+	server := NewTLSTCPServer(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	addr := "127.0.0.1:8083"
+	if err := server.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	callOnce := func(opts lib.TLSOptions) (*lib.CallResult, error) {
+		caller, err := NewTLSTCPComm(addr, opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawReq := caller.BuildReq()
+		mBytes, err := caller.Call(rawReq.Req, time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return caller.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: mBytes}), nil
+	}
+	opts := lib.TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile}
+	result, err := callOnce(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != lib.RET_CODE_SUCCESS {
+		t.Fatalf("Unexpected result: %v", result)
+	}
+	// 未提供客户端证书时服务端拒绝连接
+	if _, err := callOnce(lib.TLSOptions{CAFile: certFile}); err == nil {
+		t.Fatal("Expected an error without client certificate!")
+	}
+	// 未信任服务端证书时握手失败
+	if _, err := callOnce(lib.TLSOptions{CertFile: certFile, KeyFile: keyFile, ServerName: "localhost"}); err == nil {
+		t.Fatal("Expected an error with untrusted server certificate!")
+	}
+
+	// 启用会话恢复时第二次连接复用会话
+	caller, err := NewTLSTCPComm(addr, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm := caller.(*TCPComm)
+	for i := 0; i < 2; i++ {
+		conn, err := comm.dial(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawReq := comm.BuildReq()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := comm.roundTrip(conn, rawReq.Req); err != nil {
+			t.Fatal(err)
+		}
+		resumed := conn.(*tls.Conn).ConnectionState().DidResume
+		conn.Close()
+		if resumed != (i == 1) {
+			t.Fatalf("Unexpected session resumption! (conn=%d, resumed=%v)", i, resumed)
+		}
+	}
+
+	// 经由URL构建时mux_conns同样适用于tls://
+	caller, err = lib.NewCaller("tls://"+addr, lib.Options{
+		"ca_file": certFile, "cert_file": certFile, "key_file": keyFile, "mux_conns": "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm = caller.(*TCPComm)
+	defer comm.Close()
+	for i := 0; i < 2; i++ {
+		rawReq := comm.BuildReq()
+		resp, err := comm.Call(rawReq.Req, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := comm.CheckResp(rawReq, lib.RawResp{ID: rawReq.ID, Resp: resp}); result.Code != lib.RET_CODE_SUCCESS {
+			t.Fatalf("Unexpected result: %v", result)
+		}
+	}
+	if comm.mux == nil || comm.mux.conns[0] == nil {
+		t.Fatal("Multiplexed TLS connection is not in use!")
+	}
+	if _, ok := comm.mux.conns[0].conn.(*tls.Conn); !ok {
+		t.Fatal("Multiplexed connection is not a TLS connection!")
+	}
+
+	if _, err := (lib.TLSOptions{MinVersion: "1.4"}).Config(); err == nil {
+		t.Fatal("Expected an error with invalid min version!")
+	}
+}
+
+func TestMuxTCPComm(t *testing.T) {
+	server := NewTCPServer()
+	addr := "127.0.0.1:8085"
+	if err := server.Listen(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	caller, err := NewMuxTCPComm(addr, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm := caller.(*TCPComm)
+	defer comm.Close()
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			req, _ := json.Marshal(ServerReq{ID: id, Operands: []int{int(id), 1}, Operator: "+"})
+			rawReq := lib.RawReq{ID: id, Req: req}
+			resp, err := comm.Call(req, time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			result := comm.CheckResp(rawReq, lib.RawResp{ID: id, Resp: resp})
+			if result.Code != lib.RET_CODE_SUCCESS {
+				t.Errorf("Unexpected result: %v", result)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for i, mc := range comm.mux.conns {
+		if mc == nil || mc.broken() {
+			t.Fatalf("Multiplexed connection %d is not in use!", i)
+		}
+	}
+}
+
+func TestMuxTCPCommTimeout(t *testing.T) {
+	// 服务器不回复ID为偶数的请求
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadBytes(DELIM)
+			if err != nil {
+				return
+			}
+			var sReq ServerReq
+			json.Unmarshal(line[:len(line)-1], &sReq)
+			if sReq.ID%2 == 1 {
+				write(conn, handleReq(line[:len(line)-1]), DELIM)
+			}
+		}
+	}()
+	caller, err := NewMuxTCPComm(ln.Addr().String(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comm := caller.(*TCPComm)
+	defer comm.Close()
+	for id := int64(1); id <= 4; id++ {
+		req, _ := json.Marshal(ServerReq{ID: id, Operands: []int{1, 2}, Operator: "+"})
+		_, err := comm.Call(req, 50*time.Millisecond)
+		if (err != nil) != (id%2 == 0) {
+			t.Fatalf("Unexpected error for request %d: %v", id, err)
+		}
+	}
+	if mc := comm.mux.conns[0]; mc.broken() || len(mc.pending) != 0 {
+		t.Fatal("Timeout affected the shared connection!")
+	}
+}
+
+func TestMuxerDialOutsideLock(t *testing.T) {
+	// 位置0上的连接建立被阻塞时,位置1上的调用不受影响
+	release := make(chan struct{})
+	var dials int32
+	m := newMuxer(func(timeoutNS time.Duration) (net.Conn, error) {
+		client, server := net.Pipe()
+		go ioutil.ReadAll(server)
+		if atomic.AddInt32(&dials, 1) == 1 {
+			<-release
+		}
+		return client, nil
+	}, 2)
+	m.next = 1 // 第一个调用使用位置0
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := m.get(time.Second)
+		blocked <- err
+	}()
+	for atomic.LoadInt32(&dials) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.get(time.Second)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dialing one slot blocked the others!")
+	}
+	// 关闭后完成的连接建立不再留下可用的连接
+	m.close()
+	close(release)
+	if err := <-blocked; err != errMuxConnBroken {
+		t.Fatalf("Unexpected error after close: %v", err)
+	}
+	if m.conns[0] != nil {
+		t.Fatal("A connection was left open after close!")
+	}
+}
@@ -0,0 +1,243 @@
+package testhelper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MQTTBroker 表示一个最简的进程内MQTT 3.1.1 Broker
+// 支持QoS 0/1/2的发布确认流程和带+、#通配符的订阅,消息一律以QoS 0投递,不保留会话
+type MQTTBroker struct {
+	listener  net.Listener
+	active    uint32 // 0-未激活;1-已激活
+	mu        sync.Mutex
+	subs      map[*mqttClient][]string // 各客户端订阅的主题过滤器
+	published uint64                   // 收到的发布消息数
+}
+
+// mqttClient 表示Broker端的一个客户端连接
+type mqttClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+// NewMQTTBroker 新建一个MQTT Broker
+func NewMQTTBroker() *MQTTBroker {
+	return &MQTTBroker{subs: make(map[*mqttClient][]string)}
+}
+
+// Listen 启动对指定网络地址的监听
+func (b *MQTTBroker) Listen(addr string) error {
+	if !atomic.CompareAndSwapUint32(&b.active, 0, 1) {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		atomic.StoreUint32(&b.active, 0)
+		return err
+	}
+	b.listener = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if atomic.LoadUint32(&b.active) == 1 {
+					logger.Errorf("Broker: Request Acceptation Error: %s\n", err)
+					continue
+				}
+				break
+			}
+			go b.serve(&mqttClient{conn: conn})
+		}
+	}()
+	return nil
+}
+
+// Addr 返回Broker实际监听的地址
+func (b *MQTTBroker) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Published 返回收到的发布消息数
+func (b *MQTTBroker) Published() uint64 {
+	return atomic.LoadUint64(&b.published)
+}
+
+// Close 关闭Broker
+func (b *MQTTBroker) Close() bool {
+	if !atomic.CompareAndSwapUint32(&b.active, 1, 0) {
+		return false
+	}
+	_ = b.listener.Close()
+	return true
+}
+
+// serve 处理一个客户端连接上的报文
+func (b *MQTTBroker) serve(c *mqttClient) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+	r := bufio.NewReader(c.conn)
+	header, _, err := readMQTTFrame(r)
+	if err != nil || header>>4 != 1 {
+		return
+	}
+	c.write(0x20, []byte{0, 0})
+	for {
+		header, body, err := readMQTTFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				logger.Warnf("Broker: Packet Read Error:%s", err)
+			}
+			return
+		}
+		switch header >> 4 {
+		case 3: // PUBLISH
+			if len(body) < 2 {
+				return
+			}
+			n := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+n {
+				return
+			}
+			topic := string(body[2 : 2+n])
+			rest := body[2+n:]
+			qos := (header >> 1) & 0x03
+			if qos > 0 {
+				if len(rest) < 2 {
+					return
+				}
+				id := rest[:2]
+				rest = rest[2:]
+				if qos == 1 {
+					c.write(0x40, id) // PUBACK
+				} else {
+					c.write(0x50, id) // PUBREC
+				}
+			}
+			atomic.AddUint64(&b.published, 1)
+			b.deliver(topic, rest)
+		case 6: // PUBREL
+			c.write(0x70, body) // PUBCOMP
+		case 8: // SUBSCRIBE
+			if len(body) < 2 {
+				return
+			}
+			granted := []byte{body[0], body[1]}
+			var filters []string
+			for p := body[2:]; len(p) >= 3; {
+				n := int(binary.BigEndian.Uint16(p))
+				if len(p) < 3+n {
+					return
+				}
+				filters = append(filters, string(p[2:2+n]))
+				granted = append(granted, 0)
+				p = p[3+n:]
+			}
+			b.mu.Lock()
+			b.subs[c] = append(b.subs[c], filters...)
+			b.mu.Unlock()
+			c.write(0x90, granted) // SUBACK
+		case 12: // PINGREQ
+			c.write(0xd0, nil)
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+// deliver 以QoS 0把消息投递给订阅了匹配主题的客户端
+func (b *MQTTBroker) deliver(topic string, payload []byte) {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(topic)))
+	body = append(body, topic...)
+	body = append(body, payload...)
+	b.mu.Lock()
+	var targets []*mqttClient
+	for c, filters := range b.subs {
+		for _, filter := range filters {
+			if mqttTopicMatch(filter, topic) {
+				targets = append(targets, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, c := range targets {
+		c.write(0x30, body)
+	}
+}
+
+// write 写出一个报文
+func (c *mqttClient) write(header byte, body []byte) {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		packet = append(packet, d)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(packet); err != nil {
+		logger.Warnf("Broker: Packet Write Error: %s", err)
+	}
+}
+
+// mqttTopicMatch 判断主题是否与带通配符的主题过滤器匹配
+func mqttTopicMatch(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
+
+// readMQTTFrame 读取一个报文,返回固定报头的首字节和剩余部分
+func readMQTTFrame(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n += int(d&0x7f) * multiplier
+		if d&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,191 @@
+package testhelper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errMuxConnBroken 表示多路复用的连接已断开
+var errMuxConnBroken = errors.New("The multiplexed connection is broken!")
+
+// muxResult 表示多路复用连接上一个请求的结果
+type muxResult struct {
+	resp []byte
+	err  error
+}
+
+// muxConn 表示被多个并发调用共享的连接
+// 由一个读协程按响应中的ID把响应分发给等待中的调用
+type muxConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex // 保证每个请求被完整地写出
+	mu      sync.Mutex
+	pending map[int64]chan muxResult // 等待响应的调用,键为请求ID
+	err     error                    // 连接断开的原因,不为nil时连接不再可用
+}
+
+// newMuxConn 包装一个连接并启动读协程
+func newMuxConn(conn net.Conn) *muxConn {
+	mc := &muxConn{conn: conn, pending: make(map[int64]chan muxResult)}
+	go mc.readLoop()
+	return mc
+}
+
+// broken 判断连接是否已断开
+func (mc *muxConn) broken() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.err != nil
+}
+
+// readLoop 持续读取响应并分发,连接出错时通知所有等待中的调用
+func (mc *muxConn) readLoop() {
+	r := bufio.NewReader(mc.conn)
+	for {
+		line, err := r.ReadBytes(DELIM)
+		if err != nil {
+			mc.fail(err)
+			return
+		}
+		resp := line[:len(line)-1]
+		var sResp ServerResp
+		if err := json.Unmarshal(resp, &sResp); err != nil {
+			mc.fail(fmt.Errorf("Incorrectly formatted Resp: %s!", string(resp)))
+			return
+		}
+		mc.mu.Lock()
+		ch, ok := mc.pending[sResp.ID]
+		delete(mc.pending, sResp.ID)
+		mc.mu.Unlock()
+		// 已超时的调用不再等待,其迟到的响应被丢弃
+		if ok {
+			ch <- muxResult{resp: resp}
+		}
+	}
+}
+
+// fail 标记连接已断开,并以错误结束所有等待中的调用
+func (mc *muxConn) fail(err error) {
+	mc.mu.Lock()
+	if mc.err == nil {
+		mc.err = err
+	}
+	pending := mc.pending
+	mc.pending = make(map[int64]chan muxResult)
+	mc.mu.Unlock()
+	mc.conn.Close()
+	for _, ch := range pending {
+		ch <- muxResult{err: err}
+	}
+}
+
+// call 在共享连接上发送请求并等待ID相同的响应,超时只影响本次调用
+func (mc *muxConn) call(id int64, req []byte, timeoutNS time.Duration) ([]byte, error) {
+	ch := make(chan muxResult, 1)
+	mc.mu.Lock()
+	if mc.err != nil {
+		mc.mu.Unlock()
+		return nil, errMuxConnBroken
+	}
+	if _, ok := mc.pending[id]; ok {
+		mc.mu.Unlock()
+		return nil, fmt.Errorf("Duplicate request id on multiplexed connection! (id=%d)", id)
+	}
+	mc.pending[id] = ch
+	mc.mu.Unlock()
+
+	deadline := time.Now().Add(timeoutNS)
+	mc.writeMu.Lock()
+	mc.conn.SetWriteDeadline(deadline)
+	_, err := write(mc.conn, req, DELIM)
+	mc.writeMu.Unlock()
+	if err != nil {
+		mc.fail(err)
+		return nil, err
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case result := <-ch:
+		return result.resp, result.err
+	case <-timer.C:
+		mc.mu.Lock()
+		delete(mc.pending, id)
+		mc.mu.Unlock()
+		return nil, fmt.Errorf("Timeout waiting for multiplexed response! (id=%d)", id)
+	}
+}
+
+// muxer 表示一组多路复用的连接,断开的连接在下次使用时重建
+// 建立连接时只持有该位置的dialMu,不阻塞使用其他位置上连接的调用
+type muxer struct {
+	dial   func(timeoutNS time.Duration) (net.Conn, error)
+	dialMu []sync.Mutex // 每个位置一个,保证同一位置同时只有一个调用在建立连接
+	mu     sync.Mutex   // 保护conns和closed
+	conns  []*muxConn
+	closed bool   // 是否已关闭
+	next   uint32 // 下一个使用的连接序号
+}
+
+// newMuxer 新建一组多路复用的连接
+func newMuxer(dial func(timeoutNS time.Duration) (net.Conn, error), size int) *muxer {
+	return &muxer{dial: dial, dialMu: make([]sync.Mutex, size), conns: make([]*muxConn, size)}
+}
+
+// usable 返回位置i上可用的连接,没有时返回nil
+func (m *muxer) usable(i int) *muxConn {
+	m.mu.Lock()
+	mc := m.conns[i]
+	m.mu.Unlock()
+	if mc != nil && !mc.broken() {
+		return mc
+	}
+	return nil
+}
+
+// get 轮流选取一个可用的连接,必要时重新建立
+func (m *muxer) get(timeoutNS time.Duration) (*muxConn, error) {
+	i := int(atomic.AddUint32(&m.next, 1)) % len(m.conns)
+	if mc := m.usable(i); mc != nil {
+		return mc, nil
+	}
+	m.dialMu[i].Lock()
+	defer m.dialMu[i].Unlock()
+	// 等待期间其他调用可能已重建了连接
+	if mc := m.usable(i); mc != nil {
+		return mc, nil
+	}
+	conn, err := m.dial(timeoutNS)
+	if err != nil {
+		return nil, err
+	}
+	mc := newMuxConn(conn)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		mc.fail(errMuxConnBroken)
+		return nil, errMuxConnBroken
+	}
+	m.conns[i] = mc
+	return mc, nil
+}
+
+// close 关闭全部连接,之后建立的连接会被立即关闭
+func (m *muxer) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for i, mc := range m.conns {
+		if mc != nil {
+			mc.fail(errMuxConnBroken)
+			m.conns[i] = nil
+		}
+	}
+}
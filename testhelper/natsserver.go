@@ -0,0 +1,215 @@
+package testhelper
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// NATSServer 表示一个最简的进程内NATS服务器
+// 支持CONNECT、PING、SUB、UNSUB和PUB,主题可使用*和>通配符,不支持队列组和认证
+type NATSServer struct {
+	listener   net.Listener
+	active     uint32 // 0-未激活;1-已激活
+	mu         sync.Mutex
+	subs       map[*natsClient]map[string]string // 各客户端的订阅,键为订阅标识,值为主题
+	responders map[string]func([]byte) []byte    // 进程内的响应方,键为主题
+	published  uint64                            // 收到的发布消息数
+}
+
+// natsClient 表示服务器端的一个客户端连接
+type natsClient struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+// NewNATSServer 新建一个NATS服务器
+func NewNATSServer() *NATSServer {
+	return &NATSServer{
+		subs:       make(map[*natsClient]map[string]string),
+		responders: make(map[string]func([]byte) []byte),
+	}
+}
+
+// Respond 注册一个进程内的响应方,发往该主题且带回复主题的消息由fn生成回复
+func (s *NATSServer) Respond(subject string, fn func(payload []byte) []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responders[subject] = fn
+}
+
+// Listen 启动对指定网络地址的监听
+func (s *NATSServer) Listen(addr string) error {
+	if !atomic.CompareAndSwapUint32(&s.active, 0, 1) {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		atomic.StoreUint32(&s.active, 0)
+		return err
+	}
+	s.listener = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if atomic.LoadUint32(&s.active) == 1 {
+					logger.Errorf("Server: Request Acceptation Error: %s\n", err)
+					continue
+				}
+				break
+			}
+			go s.serve(&natsClient{conn: conn})
+		}
+	}()
+	return nil
+}
+
+// Addr 返回服务器实际监听的地址
+func (s *NATSServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Published 返回收到的发布消息数
+func (s *NATSServer) Published() uint64 {
+	return atomic.LoadUint64(&s.published)
+}
+
+// Close 关闭服务器
+func (s *NATSServer) Close() bool {
+	if !atomic.CompareAndSwapUint32(&s.active, 1, 0) {
+		return false
+	}
+	_ = s.listener.Close()
+	return true
+}
+
+// serve 处理一个客户端连接上的协议内容
+func (s *NATSServer) serve(c *natsClient) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, c)
+		s.mu.Unlock()
+		c.conn.Close()
+	}()
+	c.write(`INFO {"server_id":"loadgen","version":"0.0.0","max_payload":1048576}` + "\r\n")
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				logger.Warnf("Server: Protocol Read Error:%s", err)
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONNECT":
+		case "PING":
+			c.write("PONG\r\n")
+		case "PONG":
+		case "SUB":
+			// SUB <subject> [queue group] <sid>
+			if len(fields) < 3 {
+				c.write("-ERR 'Invalid Subscription'\r\n")
+				return
+			}
+			s.mu.Lock()
+			if s.subs[c] == nil {
+				s.subs[c] = make(map[string]string)
+			}
+			s.subs[c][fields[len(fields)-1]] = fields[1]
+			s.mu.Unlock()
+		case "UNSUB":
+			if len(fields) >= 2 {
+				s.mu.Lock()
+				delete(s.subs[c], fields[1])
+				s.mu.Unlock()
+			}
+		case "PUB":
+			// PUB <subject> [reply-to] <#bytes>
+			if len(fields) < 3 || len(fields) > 4 {
+				c.write("-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || n < 0 {
+				c.write("-ERR 'Invalid Message Size'\r\n")
+				return
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			reply := ""
+			if len(fields) == 4 {
+				reply = fields[2]
+			}
+			atomic.AddUint64(&s.published, 1)
+			s.publish(fields[1], reply, payload[:n])
+		default:
+			c.write("-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+// publish 把消息投递给匹配的订阅,并在有响应方时向回复主题发送回复
+func (s *NATSServer) publish(subject, reply string, payload []byte) {
+	type target struct {
+		c   *natsClient
+		sid string
+	}
+	s.mu.Lock()
+	var targets []target
+	for c, subs := range s.subs {
+		for sid, pattern := range subs {
+			if natsSubjectMatch(pattern, subject) {
+				targets = append(targets, target{c, sid})
+			}
+		}
+	}
+	responder := s.responders[subject]
+	s.mu.Unlock()
+	for _, t := range targets {
+		head := "MSG " + subject + " " + t.sid + " "
+		if reply != "" {
+			head += reply + " "
+		}
+		t.c.write(head + strconv.Itoa(len(payload)) + "\r\n" + string(payload) + "\r\n")
+	}
+	if responder != nil && reply != "" {
+		s.publish(reply, "", responder(payload))
+	}
+}
+
+// write 写出协议内容
+func (c *natsClient) write(data string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := io.WriteString(c.conn, data); err != nil {
+		logger.Warnf("Server: Protocol Write Error: %s", err)
+	}
+}
+
+// natsSubjectMatch 判断主题是否与带通配符的订阅主题匹配
+func natsSubjectMatch(pattern, subject string) bool {
+	ps := strings.Split(pattern, ".")
+	ss := strings.Split(subject, ".")
+	for i, p := range ps {
+		if p == ">" {
+			return i < len(ss)
+		}
+		if i >= len(ss) || (p != "*" && p != ss[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ss)
+}
@@ -0,0 +1,208 @@
+package testhelper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RedisServer 表示一个最简的进程内RESP服务器
+// 仅支持PING、ECHO、GET、SET、INCR、DEL和HELLO命令,数据保存在内存中
+type RedisServer struct {
+	listener net.Listener
+	active   uint32 // 0-未激活;1-已激活
+	mu       sync.Mutex
+	data     map[string]string
+}
+
+// NewRedisServer 新建一个RESP服务器
+func NewRedisServer() *RedisServer {
+	return &RedisServer{data: make(map[string]string)}
+}
+
+// Listen 启动对指定网络地址的监听
+func (s *RedisServer) Listen(addr string) error {
+	if !atomic.CompareAndSwapUint32(&s.active, 0, 1) {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		atomic.StoreUint32(&s.active, 0)
+		return err
+	}
+	s.listener = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if atomic.LoadUint32(&s.active) == 1 {
+					logger.Errorf("Server: Request Acceptation Error: %s\n", err)
+					continue
+				}
+				break
+			}
+			go s.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Addr 返回服务器实际监听的地址
+func (s *RedisServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close 关闭服务器
+func (s *RedisServer) Close() bool {
+	if !atomic.CompareAndSwapUint32(&s.active, 1, 0) {
+		return false
+	}
+	_ = s.listener.Close()
+	return true
+}
+
+// serve 依次处理连接上的命令,每个连接独立协商协议版本
+func (s *RedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	resp3 := false
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				logger.Warnf("Server: Command Read Error:%s", err)
+			}
+			return
+		}
+		reply := s.exec(args, &resp3)
+		w.WriteString(reply)
+		// 流水线中的命令读完后再统一发送回复
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				logger.Errorf("Server: Reply Write Error: %s", err)
+				return
+			}
+		}
+	}
+}
+
+// exec 执行一个命令并返回编码后的回复
+func (s *RedisServer) exec(args []string, resp3 *bool) string {
+	if len(args) == 0 {
+		return "-ERR empty command\r\n"
+	}
+	name := strings.ToUpper(args[0])
+	arity := map[string]int{"PING": 1, "ECHO": 2, "GET": 2, "SET": 3, "INCR": 2, "DEL": 2, "HELLO": 1}
+	n, ok := arity[name]
+	if !ok {
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+	if len(args) < n {
+		return fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(name))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch name {
+	case "PING":
+		return "+PONG\r\n"
+	case "ECHO":
+		return bulkString(args[1])
+	case "GET":
+		v, ok := s.data[args[1]]
+		if !ok {
+			if *resp3 {
+				return "_\r\n"
+			}
+			return "$-1\r\n"
+		}
+		return bulkString(v)
+	case "SET":
+		s.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "INCR":
+		v := int64(0)
+		if old, ok := s.data[args[1]]; ok {
+			var err error
+			if v, err = strconv.ParseInt(old, 10, 64); err != nil {
+				return "-ERR value is not an integer or out of range\r\n"
+			}
+		}
+		v++
+		s.data[args[1]] = strconv.FormatInt(v, 10)
+		return ":" + strconv.FormatInt(v, 10) + "\r\n"
+	case "DEL":
+		if _, ok := s.data[args[1]]; !ok {
+			return ":0\r\n"
+		}
+		delete(s.data, args[1])
+		return ":1\r\n"
+	default: // HELLO
+		version := "2"
+		if len(args) > 1 {
+			version = args[1]
+		}
+		if version != "2" && version != "3" {
+			return "-NOPROTO unsupported protocol version\r\n"
+		}
+		*resp3 = version == "3"
+		if *resp3 {
+			return "%2\r\n" + bulkString("server") + bulkString("loadgen") + bulkString("proto") + ":3\r\n"
+		}
+		return "*4\r\n" + bulkString("server") + bulkString("loadgen") + bulkString("proto") + ":2\r\n"
+	}
+}
+
+// bulkString 把字符串编码为RESP批量字符串
+func bulkString(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+// readRESPCommand 读取一个由批量字符串组成的RESP数组形式的命令
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected command line: %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid command length: %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("unexpected argument line: %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid argument length: %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// readRESPLine 读取一行并去掉CRLF
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
@@ -0,0 +1,73 @@
+package testhelper
+
+import (
+	"net/url"
+
+	loadgenlib "loadgen/lib"
+)
+
+// 以tcp://、unix://、unixpacket://和tls://注册计算协议的通讯器
+func init() {
+	loadgenlib.RegisterCaller("tcp", newCommFromURL)
+	loadgenlib.RegisterCaller("unix", newCommFromURL)
+	loadgenlib.RegisterCaller("unixpacket", newCommFromURL)
+	loadgenlib.RegisterCaller("tls", newCommFromURL)
+}
+
+// newCommFromURL 依据URL和选项构建TCP通讯器
+// 选项pool_size大于0时复用持久连接(另支持idle_timeout、max_lifetime和health_check);
+// 选项mux_conns大于0时使用多路复用的连接;tls://另支持ca_file、cert_file、key_file、
+// server_name、min_version、disable_resumption和insecure_skip_verify
+func newCommFromURL(u *url.URL, opts loadgenlib.Options) (loadgenlib.Caller, error) {
+	addr := u.Host
+	if u.Scheme == "unix" || u.Scheme == "unixpacket" {
+		addr = u.Scheme + "://" + u.Path
+	}
+	poolSize, err := opts.Int("pool_size", 0)
+	if err != nil {
+		return nil, err
+	}
+	var pool *loadgenlib.PoolConfig
+	if poolSize > 0 {
+		pool = &loadgenlib.PoolConfig{Size: uint32(poolSize)}
+		if pool.IdleTimeout, err = opts.Duration("idle_timeout", 0); err != nil {
+			return nil, err
+		}
+		if pool.MaxLifetime, err = opts.Duration("max_lifetime", 0); err != nil {
+			return nil, err
+		}
+		if pool.HealthCheck, err = opts.Bool("health_check", false); err != nil {
+			return nil, err
+		}
+	}
+	muxConns, err := opts.Int("mux_conns", 0)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "tls" {
+		tlsOpts := loadgenlib.TLSOptions{
+			CAFile:     opts.String("ca_file", ""),
+			CertFile:   opts.String("cert_file", ""),
+			KeyFile:    opts.String("key_file", ""),
+			ServerName: opts.String("server_name", ""),
+			MinVersion: opts.String("min_version", ""),
+		}
+		if tlsOpts.DisableResumption, err = opts.Bool("disable_resumption", false); err != nil {
+			return nil, err
+		}
+		if tlsOpts.InsecureSkipVerify, err = opts.Bool("insecure_skip_verify", false); err != nil {
+			return nil, err
+		}
+		if muxConns > 0 {
+			return NewMuxTLSTCPComm(addr, tlsOpts, muxConns)
+		}
+		return NewTLSTCPComm(addr, tlsOpts, pool)
+	}
+	switch {
+	case muxConns > 0:
+		return NewMuxTCPComm(addr, muxConns)
+	case pool != nil:
+		return NewPooledTCPComm(addr, *pool)
+	}
+	return NewTCPComm(addr), nil
+}
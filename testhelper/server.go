@@ -2,9 +2,11 @@ package testhelper
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"sync/atomic"
@@ -91,23 +93,18 @@ func genFormula(operands []int, operator string, result int, equal bool) string
 	return buff.String()
 }
 
-// reqHandler 把参数sResp代表的请求转换为数据并发送给连接的客户端
-func reqHandler(conn net.Conn) {
+// handleReq 处理一个序列化的请求并返回序列化的响应
+func handleReq(req []byte) []byte {
 	var errMsg string
 	var sResp ServerResp
-	req, err := read(conn, DELIM)
+	var sReq ServerReq
+	err := json.Unmarshal(req, &sReq)
 	if err != nil {
-		errMsg = fmt.Sprintf("Server: Req Read Error:%s", err)
+		errMsg = fmt.Sprintf("Server: Req Unmarshal Error:%s", err)
 	} else {
-		var sReq ServerReq
-		err := json.Unmarshal(req, &sReq)
-		if err != nil {
-			errMsg = fmt.Sprintf("Server: Req Unmarshal Error:%s", err)
-		} else {
-			sResp.ID = sReq.ID
-			sResp.Result = op(sReq.Operands, sReq.Operator)
-			sResp.Formula = genFormula(sReq.Operands, sReq.Operator, sResp.Result, true)
-		}
+		sResp.ID = sReq.ID
+		sResp.Result = op(sReq.Operands, sReq.Operator)
+		sResp.Formula = genFormula(sReq.Operands, sReq.Operator, sResp.Result, true)
 	}
 	if errMsg != "" {
 		sResp.Err = errors.New(errMsg)
@@ -116,16 +113,33 @@ func reqHandler(conn net.Conn) {
 	if err != nil {
 		logger.Errorf("Server: Resp Marshal Error:%s", err)
 	}
-	_, err = write(conn, mBytes, DELIM)
-	if err != nil {
-		logger.Errorf("Server: Resp Write Error: %s", err)
+	return mBytes
+}
+
+// reqHandler 从连接依次读取请求,并把处理结果发送给连接的客户端,直到连接关闭
+func reqHandler(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := read(conn, DELIM)
+		if err != nil {
+			if err != io.EOF {
+				logger.Warnf("Server: Req Read Error:%s", err)
+			}
+			return
+		}
+		_, err = write(conn, handleReq(req), DELIM)
+		if err != nil {
+			logger.Errorf("Server: Resp Write Error: %s", err)
+			return
+		}
 	}
 }
 
 // TCPServer 表示基于TCP协议的服务器
 type TCPServer struct {
 	listener net.Listener
-	active   uint32 // 0-未激活;2-已激活
+	active   uint32      // 0-未激活;2-已激活
+	tls      *tls.Config // TLS配置,为nil时使用明文连接
 }
 
 // NewTCPServer 新建一个基于TCP协议的服务器
@@ -133,21 +147,36 @@ func NewTCPServer() *TCPServer {
 	return &TCPServer{}
 }
 
+// NewTLSTCPServer 新建一个基于TLS的服务器,证书可由GenerateCert在本地生成
+// 在配置中要求并校验客户端证书即可用于测试双向TLS
+func NewTLSTCPServer(cfg *tls.Config) *TCPServer {
+	return &TCPServer{tls: cfg}
+}
+
 // init 初始化服务器
 func (s *TCPServer) init(addr string) error {
 	if !atomic.CompareAndSwapUint32(&s.active, 0, 1) {
 		return nil
 	}
-	ln, err := net.Listen("tcp", addr)
+	network, address, err := lib.SplitNetworkAddr(addr)
 	if err != nil {
 		atomic.StoreUint32(&s.active, 0)
 		return err
 	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		atomic.StoreUint32(&s.active, 0)
+		return err
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls)
+	}
 	s.listener = ln
 	return nil
 }
 
 // Listen 启动对指定网络地址的监听
+// 地址也可以是"unix:///path/to.sock"或"unixpacket:///path/to.sock"形式的Unix域套接字
 func (s *TCPServer) Listen(addr string) error {
 	err := s.init(addr)
 	if err != nil {
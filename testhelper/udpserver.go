@@ -0,0 +1,72 @@
+package testhelper
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// UDPServer 表示基于UDP协议的计算服务器,每个数据报是一个请求
+type UDPServer struct {
+	conn      net.PacketConn
+	active    uint32 // 0-未激活;1-已激活
+	dropEvery uint32 // 每收到多少个请求丢弃一个,0表示不丢弃
+	count     uint32 // 已收到的请求数
+}
+
+// NewUDPServer 新建一个基于UDP协议的服务器
+func NewUDPServer() *UDPServer {
+	return &UDPServer{}
+}
+
+// SetDropEvery 设置每收到n个请求丢弃其中一个(不回复),用于模拟丢包,n为0表示不丢弃
+func (s *UDPServer) SetDropEvery(n uint32) {
+	atomic.StoreUint32(&s.dropEvery, n)
+}
+
+// Listen 启动对指定网络地址的监听
+func (s *UDPServer) Listen(addr string) error {
+	if !atomic.CompareAndSwapUint32(&s.active, 0, 1) {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		atomic.StoreUint32(&s.active, 0)
+		return err
+	}
+	s.conn = conn
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				if atomic.LoadUint32(&s.active) == 1 {
+					logger.Errorf("Server: Datagram Read Error: %s\n", err)
+					continue
+				}
+				break
+			}
+			count := atomic.AddUint32(&s.count, 1)
+			if every := atomic.LoadUint32(&s.dropEvery); every > 0 && count%every == 0 {
+				continue
+			}
+			if _, err := conn.WriteTo(handleReq(buf[:n]), peer); err != nil {
+				logger.Errorf("Server: Datagram Write Error: %s\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Addr 返回服务器实际监听的地址
+func (s *UDPServer) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close 关闭服务器
+func (s *UDPServer) Close() bool {
+	if !atomic.CompareAndSwapUint32(&s.active, 1, 0) {
+		return false
+	}
+	_ = s.conn.Close()
+	return true
+}
@@ -0,0 +1,232 @@
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// yamlLine 表示YAML文档中去除注释后的一个有效行
+type yamlLine struct {
+	no     int    // 行号
+	indent int    // 缩进
+	text   string // 内容
+}
+
+// parseYAML 解析YAML文档的一个子集:块映射、块序列、标量以及空的流式集合
+// 解析结果由map[string]interface{}、[]interface{}和标量组成,可直接编码为JSON
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", i+1)
+		}
+		text = stripYAMLComment(text)
+		if text == "" || text == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{no: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.no)
+	}
+	return v, nil
+}
+
+// stripYAMLComment 去除引号之外的行尾注释
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return text
+}
+
+// yamlParser 表示基于行的YAML解析器
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// isListItem 判断行是否为序列项
+func isListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseBlock 解析指定缩进处的块映射或块序列
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isListItem(p.lines[p.pos].text) {
+		return p.parseList(indent)
+	}
+	return p.parseMap(indent)
+}
+
+// parseMap 解析块映射
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.no)
+		}
+		if isListItem(l.text) {
+			return nil, fmt.Errorf("yaml: line %d: unexpected sequence item", l.no)
+		}
+		key, rest, err := splitYAMLKey(l)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("yaml: line %d: duplicate key %q", l.no, key)
+		}
+		p.pos++
+		if rest != "" {
+			if m[key], err = parseYAMLScalar(l.no, rest); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		m[key] = nil
+		if p.pos < len(p.lines) {
+			next := p.lines[p.pos]
+			// 序列可以与其所属的键保持相同的缩进
+			if next.indent > indent || (next.indent == indent && isListItem(next.text)) {
+				if m[key], err = p.parseBlock(next.indent); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// parseList 解析块序列
+func (p *yamlParser) parseList(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent || (l.indent == indent && !isListItem(l.text)) {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", l.no)
+		}
+		item := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if item == "" {
+			p.pos++
+			var v interface{}
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				var err error
+				if v, err = p.parseBlock(p.lines[p.pos].indent); err != nil {
+					return nil, err
+				}
+			}
+			list = append(list, v)
+			continue
+		}
+		// 形如"- key: value"的序列项,将其余部分视为缩进更深的映射
+		if _, _, err := splitYAMLKey(yamlLine{no: l.no, text: item}); err == nil && !isQuoted(item) {
+			p.lines[p.pos] = yamlLine{no: l.no, indent: l.indent + len(l.text) - len(item), text: item}
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		v, err := parseYAMLScalar(l.no, item)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		p.pos++
+	}
+	return list, nil
+}
+
+// splitYAMLKey 把"key: value"形式的行拆分为键和值
+func splitYAMLKey(l yamlLine) (string, string, error) {
+	idx := -1
+	for i := 0; i < len(l.text); i++ {
+		if l.text[i] == ':' && (i == len(l.text)-1 || l.text[i+1] == ' ') {
+			idx = i
+			break
+		}
+	}
+	if idx <= 0 {
+		return "", "", fmt.Errorf("yaml: line %d: mapping key expected", l.no)
+	}
+	key := strings.TrimSpace(l.text[:idx])
+	if isQuoted(key) {
+		key = key[1 : len(key)-1]
+	}
+	return key, strings.TrimSpace(l.text[idx+1:]), nil
+}
+
+// isQuoted 判断文本是否为引号包围的字符串
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0]
+}
+
+// parseYAMLScalar 解析标量值
+func parseYAMLScalar(no int, s string) (interface{}, error) {
+	switch {
+	case s[0] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("yaml: line %d: invalid quoted string %s", no, s)
+		}
+		return v, nil
+	case s[0] == '\'':
+		if !isQuoted(s) {
+			return nil, fmt.Errorf("yaml: line %d: invalid quoted string %s", no, s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case s == "[]":
+		return []interface{}{}, nil
+	case s == "{}":
+		return map[string]interface{}{}, nil
+	case s[0] == '[' || s[0] == '{' || s[0] == '&' || s[0] == '*' || s[0] == '|' || s[0] == '>':
+		return nil, fmt.Errorf("yaml: line %d: unsupported syntax %s", no, s)
+	}
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f, nil
+	}
+	return s, nil
+}